@@ -0,0 +1,132 @@
+// Copyright 2016 Markus Lindenberg
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+)
+
+// XML structure of /admin/stats, limited to the fields that are merged into
+// the status. Pointers tell absent elements from zero values.
+type IcecastAdminStats struct {
+	ClientConnections       *int `xml:"client_connections"`
+	Connections             *int `xml:"connections"`
+	ListenerConnections     *int `xml:"listener_connections"`
+	SourceClientConnections *int `xml:"source_client_connections"`
+	StatsConnections        *int `xml:"stats_connections"`
+	FileConnections         *int `xml:"file_connections"`
+	BannedIPs               *int `xml:"banned_IPs"`
+	Listeners               *int `xml:"listeners"`
+	Sources                 *int `xml:"sources"`
+
+	Host     string `xml:"host"`
+	ServerID string `xml:"server_id"`
+
+	Source []struct {
+		Mount               string   `xml:"mount,attr"`
+		Listeners           *int     `xml:"listeners"`
+		ListenerConnections *int     `xml:"listener_connections"`
+		QueueSize           *int     `xml:"queue_size"`
+		BurstSize           *int     `xml:"burst_size"`
+		MaxListeners        *string  `xml:"max_listeners"`
+		SlowListeners       *int     `xml:"slow_listeners"`
+		IncomingBitrate     *float64 `xml:"incoming_bitrate"`
+		OutgoingKbitrate    *float64 `xml:"outgoing_kbitrate"`
+		TotalBytesRead      *int64   `xml:"total_bytes_read"`
+		TotalBytesSent      *int64   `xml:"total_bytes_sent"`
+	} `xml:"source"`
+}
+
+// mergeAdminStats fetches /admin/stats, adds its connection counters to s and
+// overwrites the fields of the sources in s with the admin values of the same
+// mount, where present. Mounts only found in /admin/stats are ignored, as they
+// lack the listenurl and server_type labels.
+func (e *Exporter) mergeAdminStats(ctx context.Context, s *IcecastStatus) error {
+	body, err := e.get(ctx, e.adminURI("/admin/stats", nil))
+	if err != nil {
+		return err
+	}
+	var stats IcecastAdminStats
+	if err := xml.Unmarshal(body, &stats); err != nil {
+		return err
+	}
+
+	s.Icestats.ClientConnections = stats.ClientConnections
+	s.Icestats.Connections = stats.Connections
+	s.Icestats.ListenerConnections = stats.ListenerConnections
+	s.Icestats.SourceClientConnections = stats.SourceClientConnections
+	s.Icestats.StatsConnections = stats.StatsConnections
+	if stats.BannedIPs != nil {
+		s.Icestats.BannedIPs = stats.BannedIPs
+	}
+	if stats.FileConnections != nil {
+		s.Icestats.FileConnections = *stats.FileConnections
+	}
+	s.Icestats.Listeners = stats.Listeners
+	s.Icestats.Sources = stats.Sources
+	// Stripped down status documents may lack the server info.
+	if s.Icestats.Host == "" {
+		s.Icestats.Host = stats.Host
+	}
+	if s.Icestats.ServerID == "" {
+		s.Icestats.ServerID = stats.ServerID
+	}
+
+	for _, admin := range stats.Source {
+		for i := range s.Icestats.Source {
+			source := &s.Icestats.Source[i]
+			if mountOf(source.Listenurl) != admin.Mount {
+				continue
+			}
+			if admin.Listeners != nil {
+				source.Listeners = *admin.Listeners
+			}
+			if admin.ListenerConnections != nil {
+				source.ListenerConnections = admin.ListenerConnections
+			}
+			if admin.QueueSize != nil {
+				source.QueueSize = *admin.QueueSize
+			}
+			if admin.BurstSize != nil {
+				source.BurstSize = admin.BurstSize
+			}
+			if admin.MaxListeners != nil {
+				if limit, err := parseLimit(strings.TrimSpace(*admin.MaxListeners)); err == nil {
+					source.MaxListeners = &limit
+				}
+			}
+			if admin.SlowListeners != nil {
+				source.SlowListeners = admin.SlowListeners
+			}
+			if admin.IncomingBitrate != nil {
+				bitrate := Number(*admin.IncomingBitrate)
+				source.IncomingBitrate = &bitrate
+			}
+			if admin.OutgoingKbitrate != nil {
+				bitrate := Number(*admin.OutgoingKbitrate)
+				source.OutgoingKbitrate = &bitrate
+			}
+			if admin.TotalBytesRead != nil {
+				source.TotalBytesRead = admin.TotalBytesRead
+			}
+			if admin.TotalBytesSent != nil {
+				source.TotalBytesSent = admin.TotalBytesSent
+			}
+		}
+	}
+	return nil
+}
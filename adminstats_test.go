@@ -0,0 +1,108 @@
+// Copyright 2016 Markus Lindenberg
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testAdminStats = `<?xml version="1.0"?>
+<icestats>
+  <client_connections>42</client_connections>
+  <file_connections>7</file_connections>
+  <listeners>12</listeners>
+  <server_id>Icecast 2.4.4</server_id>
+  <host>admin.example.com</host>
+  <source mount="/live">
+    <listeners>10</listeners>
+    <max_listeners>unlimited</max_listeners>
+    <slow_listeners>1</slow_listeners>
+    <incoming_bitrate>128000</incoming_bitrate>
+  </source>
+  <source mount="/hidden">
+    <listeners>2</listeners>
+  </source>
+</icestats>`
+
+func TestMergeAdminStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/admin/stats" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testAdminStats))
+	}))
+	defer srv.Close()
+
+	var s IcecastStatus
+	if err := json.Unmarshal([]byte(`{"icestats":{
+		"host":"status.example.com",
+		"file_connections":3,
+		"banned_IPs":5,
+		"source":[
+			{"listenurl":"http://status.example.com:8000/live","listeners":8,"queue_size":4096,"max_listeners":100},
+			{"listenurl":"http://status.example.com:8000/other","listeners":1}
+		]}}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	e := NewExporter(srv.URL+"/status-json.xsl", testOptions)
+	if err := e.mergeAdminStats(context.Background(), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := s.Icestats
+	if stats.ClientConnections == nil || *stats.ClientConnections != 42 {
+		t.Errorf("client_connections = %v, want 42", stats.ClientConnections)
+	}
+	if stats.FileConnections != 7 {
+		t.Errorf("file_connections = %d, want the admin value 7", stats.FileConnections)
+	}
+	if stats.BannedIPs == nil || *stats.BannedIPs != 5 {
+		t.Errorf("banned_IPs = %v, want the status value 5 kept", stats.BannedIPs)
+	}
+	if stats.Host != "status.example.com" {
+		t.Errorf("host = %q, want the status value kept", stats.Host)
+	}
+	if stats.ServerID != "Icecast 2.4.4" {
+		t.Errorf("server_id = %q, want it filled in from the admin stats", stats.ServerID)
+	}
+
+	if len(stats.Source) != 2 {
+		t.Fatalf("got %d sources, want 2 as /hidden lacks a listenurl", len(stats.Source))
+	}
+	live, other := stats.Source[0], stats.Source[1]
+	if live.Listeners != 10 {
+		t.Errorf("/live listeners = %d, want the admin value 10", live.Listeners)
+	}
+	if live.QueueSize != 4096 {
+		t.Errorf("/live queue_size = %d, want the status value 4096 kept", live.QueueSize)
+	}
+	if live.MaxListeners == nil || *live.MaxListeners != 0 {
+		t.Errorf("/live max_listeners = %v, want unlimited from the admin stats", live.MaxListeners)
+	}
+	if live.SlowListeners == nil || *live.SlowListeners != 1 {
+		t.Errorf("/live slow_listeners = %v, want 1", live.SlowListeners)
+	}
+	if live.IncomingBitrate == nil || *live.IncomingBitrate != 128000 {
+		t.Errorf("/live incoming_bitrate = %v, want 128000", live.IncomingBitrate)
+	}
+	if other.Listeners != 1 || other.SlowListeners != nil {
+		t.Errorf("/other was changed without admin stats: %+v", other)
+	}
+}
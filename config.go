@@ -0,0 +1,94 @@
+// Copyright 2016 Markus Lindenberg
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the file given by -config.file.
+type Config struct {
+	Servers []ServerConfig `yaml:"servers"`
+}
+
+// ServerConfig is an Icecast server to scrape. Its metrics get a server label
+// with the value of Name. ExpectedMounts overrides -icecast.expected-mounts.
+// RelayOf is the name of the server this one relays, to compare their mount
+// points. Headers are sent in addition to -icecast.header, replacing those of
+// the same name.
+type ServerConfig struct {
+	Name           string            `yaml:"name"`
+	ScrapeURI      string            `yaml:"scrape_uri"`
+	ExpectedMounts []string          `yaml:"expected_mounts"`
+	RelayOf        string            `yaml:"relay_of"`
+	Headers        map[string]string `yaml:"headers"`
+}
+
+// options returns opts with the settings of the server applied.
+func (s ServerConfig) options(opts Options) Options {
+	if len(s.ExpectedMounts) > 0 {
+		opts.ExpectedMounts = s.ExpectedMounts
+	}
+	if len(s.Headers) > 0 {
+		headers := http.Header{}
+		for name, values := range opts.Headers {
+			headers[name] = values
+		}
+		for name, value := range s.Headers {
+			headers.Set(name, value)
+		}
+		opts.Headers = headers
+	}
+	return opts
+}
+
+// loadConfig reads and validates the config file filename.
+func loadConfig(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, err
+	}
+
+	if len(config.Servers) == 0 {
+		return nil, fmt.Errorf("no servers configured")
+	}
+	names := map[string]bool{}
+	for i, server := range config.Servers {
+		if server.Name == "" {
+			return nil, fmt.Errorf("server %d has no name", i+1)
+		}
+		if names[server.Name] {
+			return nil, fmt.Errorf("duplicate server name %q", server.Name)
+		}
+		names[server.Name] = true
+		if config.Servers[i].ScrapeURI, err = normalizeScrapeURI(server.ScrapeURI); err != nil {
+			return nil, fmt.Errorf("invalid scrape URI of server %q: %v", server.Name, err)
+		}
+	}
+	for _, server := range config.Servers {
+		if server.RelayOf == server.Name || server.RelayOf != "" && !names[server.RelayOf] {
+			return nil, fmt.Errorf("server %q relays unknown server %q", server.Name, server.RelayOf)
+		}
+	}
+	return &config, nil
+}
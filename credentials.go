@@ -0,0 +1,71 @@
+// Copyright 2016 Markus Lindenberg
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// credentialCacheTTL is how long the output of the credential command is
+// reused, so it isn't run for every request of a scrape.
+const credentialCacheTTL = time.Minute
+
+// credentialHelper runs an external command, e.g. one fetching a secret from
+// Vault, whose output is "username:password" for Basic authentication or a
+// bearer token.
+type credentialHelper struct {
+	command string
+
+	credentials string
+	expiry      time.Time
+}
+
+// newCredentialHelper returns a credentialHelper running command, or nil if
+// command is empty.
+func newCredentialHelper(command string) *credentialHelper {
+	if command == "" {
+		return nil
+	}
+	return &credentialHelper{command: command}
+}
+
+// authorize adds the credentials printed by the command to req, running the
+// command if the cached output has expired.
+func (h *credentialHelper) authorize(ctx context.Context, req *http.Request) error {
+	if time.Now().After(h.expiry) {
+		out, err := exec.CommandContext(ctx, "sh", "-c", h.command).Output()
+		if err != nil {
+			return fmt.Errorf("credential command failed: %v", err)
+		}
+		credentials := strings.TrimSpace(string(out))
+		if credentials == "" {
+			return fmt.Errorf("credential command printed nothing")
+		}
+		h.credentials = credentials
+		h.expiry = time.Now().Add(credentialCacheTTL)
+	}
+
+	if i := strings.Index(h.credentials, ":"); i >= 0 {
+		req.SetBasicAuth(h.credentials[:i], h.credentials[i+1:])
+	} else {
+		req.Header.Set("Authorization", "Bearer "+h.credentials)
+	}
+	return nil
+}
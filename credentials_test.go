@@ -0,0 +1,96 @@
+// Copyright 2016 Markus Lindenberg
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// credentialScript writes a script printing output that logs each of its runs,
+// and returns its path and a function returning the number of runs.
+func credentialScript(t *testing.T, output string) (string, func() int) {
+	t.Helper()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "credentials.sh")
+	runs := filepath.Join(dir, "runs")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho run >> '"+runs+"'\nprintf '%s\\n' '"+output+"'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script, func() int {
+		data, _ := ioutil.ReadFile(runs)
+		return strings.Count(string(data), "run\n")
+	}
+}
+
+func TestCredentialHelper(t *testing.T) {
+	if newCredentialHelper("") != nil {
+		t.Error("got a credential helper without a command")
+	}
+
+	for _, test := range []struct {
+		output        string
+		authorization string
+	}{
+		{"admin:hackme", "Basic YWRtaW46aGFja21l"},
+		{"  s3cr3t-token  ", "Bearer s3cr3t-token"},
+	} {
+		script, runs := credentialScript(t, test.output)
+		h := newCredentialHelper(script)
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost:8000/admin/stats", nil)
+			if err := h.authorize(context.Background(), req); err != nil {
+				t.Fatalf("%q: %v", test.output, err)
+			}
+			if got := req.Header.Get("Authorization"); got != test.authorization {
+				t.Errorf("%q: Authorization = %q, want %q", test.output, got, test.authorization)
+			}
+		}
+		if n := runs(); n != 1 {
+			t.Errorf("%q: command ran %d times, want its output cached", test.output, n)
+		}
+
+		h.expiry = time.Now().Add(-time.Second)
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8000/admin/stats", nil)
+		if err := h.authorize(context.Background(), req); err != nil {
+			t.Fatalf("%q: %v", test.output, err)
+		}
+		if n := runs(); n != 2 {
+			t.Errorf("%q: command ran %d times after the cache expired, want 2", test.output, n)
+		}
+	}
+}
+
+func TestCredentialHelperErrors(t *testing.T) {
+	empty, _ := credentialScript(t, "")
+	for command, want := range map[string]string{
+		empty:    "printed nothing",
+		"exit 1": "credential command failed",
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8000/admin/stats", nil)
+		err := newCredentialHelper(command).authorize(context.Background(), req)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got error %v, want %q", command, err, want)
+		}
+		if got := req.Header.Get("Authorization"); got != "" {
+			t.Errorf("%q: Authorization = %q, want none", command, got)
+		}
+	}
+}
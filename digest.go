@@ -0,0 +1,151 @@
+// Copyright 2016 Markus Lindenberg
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// digestTransport answers HTTP Digest challenges (RFC 2617) as sent by older
+// Icecast admin interfaces. Only MD5 with qop "auth" or without qop is
+// supported, and as the exporter only sends GET requests without a body, the
+// request is simply repeated with credentials after the 401 response.
+type digestTransport struct {
+	username string
+	password string
+	next     http.RoundTripper
+}
+
+func (t *digestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if !strings.HasPrefix(strings.ToLower(challenge), "digest ") {
+		return nil, fmt.Errorf("server doesn't offer Digest authentication, got %q", challenge)
+	}
+
+	authorization, err := t.authorization(req, parseDigestChallenge(challenge[len("digest "):]))
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", authorization)
+	return t.next.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the next transport.
+func (t *digestTransport) CloseIdleConnections() {
+	if c, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// authorization returns the Authorization header answering challenge.
+func (t *digestTransport) authorization(req *http.Request, challenge map[string]string) (string, error) {
+	if algorithm := challenge["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return "", fmt.Errorf("unsupported Digest algorithm %q", algorithm)
+	}
+	qop := ""
+	if challenge["qop"] != "" {
+		for _, q := range strings.Split(challenge["qop"], ",") {
+			if strings.TrimSpace(q) == "auth" {
+				qop = "auth"
+			}
+		}
+		if qop == "" {
+			return "", fmt.Errorf("unsupported Digest qop %q", challenge["qop"])
+		}
+	}
+
+	uri := req.URL.RequestURI()
+	ha1 := md5Hex(t.username + ":" + challenge["realm"] + ":" + t.password)
+	ha2 := md5Hex(req.Method + ":" + uri)
+
+	fields := []string{
+		fmt.Sprintf("username=%q", t.username),
+		fmt.Sprintf("realm=%q", challenge["realm"]),
+		fmt.Sprintf("nonce=%q", challenge["nonce"]),
+		fmt.Sprintf("uri=%q", uri),
+	}
+	if qop == "" {
+		fields = append(fields, fmt.Sprintf("response=%q", md5Hex(ha1+":"+challenge["nonce"]+":"+ha2)))
+	} else {
+		// Every request gets a fresh challenge, so the nonce count is always 1.
+		nc := "00000001"
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		cnonce := hex.EncodeToString(b)
+		response := md5Hex(ha1 + ":" + challenge["nonce"] + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+		fields = append(fields, fmt.Sprintf("response=%q", response),
+			"qop="+qop, "nc="+nc, fmt.Sprintf("cnonce=%q", cnonce))
+	}
+	if challenge["algorithm"] != "" {
+		fields = append(fields, "algorithm="+challenge["algorithm"])
+	}
+	if opaque, ok := challenge["opaque"]; ok {
+		fields = append(fields, fmt.Sprintf("opaque=%q", opaque))
+	}
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+// parseDigestChallenge parses the comma separated key=value pairs of a Digest
+// challenge, where values may be quoted and contain commas.
+func parseDigestChallenge(s string) map[string]string {
+	params := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; {
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimSpace(s[eq+1:])
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				end = len(s) - 1
+			}
+			value, s = s[1:end+1], s[end+1:]
+			s = strings.TrimPrefix(s, `"`)
+		} else if comma := strings.Index(s, ","); comma >= 0 {
+			value, s = s[:comma], s[comma:]
+		} else {
+			value, s = s, ""
+		}
+		params[key] = strings.TrimSpace(value)
+		s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), ","))
+	}
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
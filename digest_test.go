@@ -0,0 +1,83 @@
+// Copyright 2016 Markus Lindenberg
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// digestServer returns a server that sends challenge to requests without
+// credentials and checks the Digest response of admin:hackme otherwise.
+func digestServer(challenge string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Digest ") {
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		c := parseDigestChallenge(challenge[len("Digest "):])
+		a := parseDigestChallenge(authorization[len("Digest "):])
+		ha1 := md5Hex("admin:" + c["realm"] + ":hackme")
+		ha2 := md5Hex(r.Method + ":" + r.URL.RequestURI())
+		want := md5Hex(ha1 + ":" + c["nonce"] + ":" + ha2)
+		if c["qop"] != "" {
+			want = md5Hex(ha1 + ":" + c["nonce"] + ":" + a["nc"] + ":" + a["cnonce"] + ":" + a["qop"] + ":" + ha2)
+		}
+		if a["username"] != "admin" || a["uri"] != r.URL.RequestURI() || a["opaque"] != c["opaque"] || a["response"] != want {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+}
+
+func TestDigestTransport(t *testing.T) {
+	for _, challenge := range []string{
+		`Digest realm="Icecast2 Server", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", qop="auth,auth-int", opaque="5ccc069c403ebaf9f0171e9517f40e41"`,
+		`Digest realm="Icecast2 Server", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", algorithm=MD5`,
+	} {
+		srv := digestServer(challenge)
+		client := &http.Client{Transport: &digestTransport{username: "admin", password: "hackme", next: http.DefaultTransport}}
+		resp, err := client.Get(srv.URL + "/admin/stats?mount=/live")
+		if err != nil {
+			t.Errorf("%s: %v", challenge, err)
+		} else if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: got status %d, want 200", challenge, resp.StatusCode)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		srv.Close()
+	}
+}
+
+func TestDigestTransportWithoutDigestChallenge(t *testing.T) {
+	srv := digestServer(`Basic realm="Icecast2 Server"`)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &digestTransport{username: "admin", password: "hackme", next: http.DefaultTransport}}
+	resp, err := client.Get(srv.URL + "/admin/stats")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("got no error for a Basic challenge")
+	}
+	if !strings.Contains(err.Error(), "doesn't offer Digest authentication") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -15,69 +15,444 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"html/template"
+	"io"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
 	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/oschwald/geoip2-golang"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
+	"golang.org/x/net/proxy"
 )
 
 const (
 	namespace = "icecast"
+
+	// defaultStatusPath is used for scrape URIs that don't specify a path.
+	defaultStatusPath = "/status-json.xsl"
+
+	defaultLandingPage = `<html>
+             <head><title>Icecast Exporter</title></head>
+             <body>
+             <h1>Icecast Exporter</h1>
+             <p><a href='{{.MetricsPath}}'>Metrics</a></p>
+             </body>
+             </html>`
 )
 
 var (
 	labelNames = []string{"listenurl", "server_type"}
+
+	// reservedLabelNames can't be used as mount labels, as -config.file,
+	// /probe or metrics with additional labels add them.
+	reservedLabelNames = []string{"server", "target", "country", "title", "artist", "server_name", "server_description", "genre", "quantile", "player"}
 )
 
+// ISO8601 is a timestamp as Icecast reports it. Timestamps that are missing or
+// can't be parsed are left zero instead of failing the scrape, see
+// Exporter.timestamp.
 type ISO8601 time.Time
 
 func (ts ISO8601) Time() time.Time {
 	return time.Time(ts)
 }
 
+// timestampLayouts are tried in order: the *_iso8601 fields of Icecast, their
+// variants with Z or +00:00 offsets, and the human readable stream_start and
+// server_start fields.
+var timestampLayouts = []string{
+	"2006-01-02T15:04:05-0700",
+	time.RFC3339Nano,
+	time.RFC1123Z,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	time.RFC1123,
+}
+
 func (ts *ISO8601) UnmarshalJSON(data []byte) error {
-	parsed, err := time.Parse(`"2006-01-02T15:04:05-0700"`, string(data))
+	*ts = ISO8601{}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil || s == "" {
+		return nil
+	}
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			*ts = ISO8601(parsed)
+			return nil
+		}
+	}
+	log.Debugf("Ignoring unparseable timestamp %q", s)
+	return nil
+}
+
+// Flag is a JSON boolean that Icecast may also encode as 0/1, quoted or not.
+// Source clients set it through ice-public, so any nonzero number is true and
+// values that aren't booleans or numbers decode to false.
+type Flag bool
+
+func (f *Flag) UnmarshalJSON(data []byte) error {
+	*f = false
+	switch s := strings.Trim(string(data), `"`); s {
+	case "true":
+		*f = true
+	case "false", "", "null":
+	default:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			log.Debugf("Ignoring unparseable boolean %s", data)
+			return nil
+		}
+		*f = n != 0
+	}
+	return nil
+}
+
+// Text is a JSON string that Icecast encodes as a number if it looks like
+// one, e.g. a title like "1999".
+type Text string
+
+func (t *Text) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*t = ""
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*t = Text(s)
+		return nil
+	}
+	*t = Text(data)
+	return nil
+}
+
+// Number is a JSON number that Icecast may also encode as a string. Source
+// clients fill some fields with whatever they like, e.g. "128k", so values
+// that aren't numbers decode to 0 rather than failing the whole status.
+type Number float64
+
+func (n *Number) UnmarshalJSON(data []byte) error {
+	*n = 0
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
 	if err != nil {
-		return err
+		log.Debugf("Ignoring unparseable number %s", data)
+		return nil
 	}
-	*ts = ISO8601(parsed)
+	*n = Number(f)
 	return nil
 }
 
+// Limit is a Number that decodes "unlimited", as Icecast reports a missing
+// limit, to 0.
+type Limit Number
+
+func (l *Limit) UnmarshalJSON(data []byte) error {
+	if strings.Trim(string(data), `"`) == "unlimited" {
+		*l = 0
+		return nil
+	}
+	return (*Number)(l).UnmarshalJSON(data)
+}
+
+// parseLimit parses a limit from XML, like Limit does from JSON.
+func parseLimit(s string) (Limit, error) {
+	if s == "" || s == "unlimited" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return Limit(f), err
+}
+
+// timestamp returns t in seconds since the epoch. For the zero time that
+// missing or unparseable timestamps decode to, it returns NaN or 0 depending
+// on -icecast.timestamp-on-error, or false if the series should be skipped.
+func (e *Exporter) timestamp(t time.Time) (float64, bool) {
+	if !t.IsZero() {
+		return float64(t.Unix()), true
+	}
+	switch e.timestampOnError {
+	case "skip":
+		return 0, false
+	case "zero":
+		return 0, true
+	default:
+		return math.NaN(), true
+	}
+}
+
 type IcecastStatusSource struct {
-	Listeners   int     `json:"listeners"`
-	Listenurl   string  `json:"listenurl"`
-	ServerType  string  `json:"server_type"`
-	StreamStart ISO8601 `json:"stream_start_iso8601"`
+	Artist              Text    `json:"artist"`
+	AudioBitrate        *Number `json:"audio_bitrate"`
+	AudioChannels       *Number `json:"audio_channels"`
+	AudioInfo           Text    `json:"audio_info"`
+	AudioSamplerate     *Number `json:"audio_samplerate"`
+	Bitrate             *Number `json:"bitrate"`
+	BurstSize           *int    `json:"burst_size"`
+	Channels            *Number `json:"channels"`
+	Genre               Text    `json:"genre"`
+	IncomingBitrate     *Number `json:"incoming_bitrate"`
+	Listeners           int     `json:"listeners"`
+	ListenerConnections *int    `json:"listener_connections"`
+	ListenerPeak        *int    `json:"listener_peak"`
+	Listenurl           string  `json:"listenurl"`
+	MaxListeners        *Limit  `json:"max_listeners"`
+	OutgoingKbitrate    *Number `json:"outgoing_kbitrate"`
+	Public              Flag    `json:"public"`
+	QueueSize           int     `json:"queue_size"`
+	Samplerate          *Number `json:"samplerate"`
+	ServerDescription   Text    `json:"server_description"`
+	ServerName          Text    `json:"server_name"`
+	ServerType          string  `json:"server_type"`
+	SlowListeners       *int    `json:"slow_listeners"`
+	StreamStart         ISO8601 `json:"stream_start_iso8601"`
+	StreamStartText     ISO8601 `json:"stream_start"`
+	Title               Text    `json:"title"`
+	TotalBytesRead      *int64  `json:"total_bytes_read"`
+	TotalBytesSent      *int64  `json:"total_bytes_sent"`
+
+	// Listeners from /admin/listclients, if enabled.
+	Clients []IcecastListener `json:"-"`
+
+	// single is set if this is the only source and Icecast reported it as
+	// an object, see IcecastSources.
+	single bool
+}
+
+// bitrate returns the bitrate of the source in kbit/s, taken from bitrate or,
+// as some sources only set that, from audio_bitrate in bit/s. It returns
+// false if neither is set to a positive value.
+func (s IcecastStatusSource) bitrate() (float64, bool) {
+	if s.Bitrate != nil && *s.Bitrate > 0 {
+		return float64(*s.Bitrate), true
+	}
+	if s.AudioBitrate != nil && *s.AudioBitrate > 0 {
+		return float64(*s.AudioBitrate) / 1000, true
+	}
+	return 0, false
+}
+
+// audioInfo returns the value of key in audio_info, which source clients set
+// like "ice-samplerate=44100;ice-bitrate=128;ice-channels=2", with or
+// without the "ice-" prefix.
+func (s IcecastStatusSource) audioInfo(key string) (float64, bool) {
+	for _, field := range strings.Split(string(s.AudioInfo), ";") {
+		eq := strings.Index(field, "=")
+		if eq < 0 || strings.TrimPrefix(strings.TrimSpace(field[:eq]), "ice-") != key {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(field[eq+1:]), 64)
+		return value, err == nil && value > 0
+	}
+	return 0, false
+}
+
+// audioField returns the first of fields that is set to a positive value, or
+// else the value of key in audio_info.
+func (s IcecastStatusSource) audioField(key string, fields ...*Number) (float64, bool) {
+	for _, field := range fields {
+		if field != nil && *field > 0 {
+			return float64(*field), true
+		}
+	}
+	return s.audioInfo(key)
+}
+
+// IcecastStats holds the server-wide fields of the Icecast status.
+type IcecastStats struct {
+	Admin           string  `json:"admin"`
+	FileConnections int     `json:"file_connections"`
+	Host            string  `json:"host"`
+	ListenerPeak    int     `json:"listener_peak"`
+	Location        string  `json:"location"`
+	ServerID        string  `json:"server_id"`
+	ServerStart     ISO8601 `json:"server_start_iso8601"`
+	ServerStartText ISO8601 `json:"server_start"`
+
+	// Connection counters, only reported by /admin/stats.
+	ClientConnections       *int `json:"client_connections"`
+	Connections             *int `json:"connections"`
+	ListenerConnections     *int `json:"listener_connections"`
+	SourceClientConnections *int `json:"source_client_connections"`
+	StatsConnections        *int `json:"stats_connections"`
+	// Only reported by Icecast-KH.
+	BannedIPs *int `json:"banned_IPs"`
+	// Listeners and number of all sources, only reported by /admin/stats.
+	Listeners *int `json:"listeners"`
+	Sources   *int `json:"sources"`
+
+	// Mount points listed by /admin/listmounts.
+	Mounts []IcecastMount `json:"-"`
+}
+
+// parseServerID splits a server_id like "Icecast 2.4.4" into software and
+// version. If it doesn't look like that, the whole server_id is returned as
+// version.
+func parseServerID(serverID string) (software, version string) {
+	fields := strings.Fields(serverID)
+	if len(fields) == 2 && fields[1][0] >= '0' && fields[1][0] <= '9' {
+		return fields[0], fields[1]
+	}
+	return "", serverID
 }
 
-// JSON structure if zero or multiple streams active
+// JSON structure of the status document
 type IcecastStatus struct {
 	Icestats struct {
-		ServerStart ISO8601					`json:"server_start_iso8601"`
-		Source      []IcecastStatusSource 	`json:"source,omitifempty"`
+		IcecastStats
+		Source IcecastSources `json:"source,omitifempty"`
 	} `json:"icestats"`
 }
 
-// JSON structure if exactly one stream active
-type IcecastStatusSingle struct {
-	Icestats struct {
-		ServerStart ISO8601 				`json:"server_start_iso8601"`
-		Source      IcecastStatusSource 	`json:"source"`
-	} `json:"icestats"`
+// singleSource returns whether source was an object instead of an array.
+func (s *IcecastStatus) singleSource() bool {
+	return len(s.Icestats.Source) == 1 && s.Icestats.Source[0].single
+}
+
+// IcecastSources are the sources of the status. If exactly one stream is
+// active, Icecast reports "source" as an object instead of an array.
+type IcecastSources []IcecastStatusSource
+
+func (l *IcecastSources) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	if len(data) > 0 && data[0] == '{' {
+		single := IcecastStatusSource{single: true}
+		if err := json.Unmarshal(data, &single); err != nil {
+			return err
+		}
+		*l = IcecastSources{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]IcecastStatusSource)(l))
+}
+
+// Options configures how an Exporter scrapes Icecast.
+type Options struct {
+	// TLSConfig is used for HTTPS scrapes.
+	TLSConfig *tls.Config
+	// ProxyURL is an HTTP, HTTPS or SOCKS5 proxy to scrape Icecast through.
+	// If nil, the proxy environment variables are honored.
+	ProxyURL *url.URL
+	// UnixSocket is a socket to connect to instead of the host of the
+	// scrape URI, which is still sent as Host header.
+	UnixSocket string
+	// IPProtocol is "ip4" or "ip6" to connect to Icecast over that IP version
+	// only, or empty for either. With IPProtocolFallback, the other version
+	// is tried if connecting fails.
+	IPProtocol         string
+	IPProtocolFallback bool
+	// SourceAddress is the local IP address to connect to Icecast from.
+	SourceAddress net.IP
+	// MaxRedirects is the number of redirects followed. Zero disables
+	// following redirects.
+	MaxRedirects int
+	// ConnectTimeout limits establishing the connection to Icecast.
+	ConnectTimeout time.Duration
+	// TLSHandshakeTimeout limits the TLS handshake after connecting.
+	TLSHandshakeTimeout time.Duration
+	// RequestTimeout limits the whole request, including connecting, and
+	// also all requests of a scrape together, e.g. to the admin pages. Zero
+	// means no limit.
+	RequestTimeout time.Duration
+	// BearerToken is sent in the Authorization header of each scrape if set.
+	BearerToken string
+	// Username and Password are sent using Auth, which is "basic" (the
+	// default) or "digest", if Username is set.
+	Username string
+	Password string
+	Auth     string
+	// CredentialCommand is run through sh to get credentials, see
+	// credentialHelper.
+	CredentialCommand string
+	// Headers are added to each scrape request.
+	Headers http.Header
+	// UserAgent is sent unless Headers contain a User-Agent.
+	UserAgent string
+	// CacheTTL is how long a successfully scraped status is reused instead
+	// of scraping Icecast on every collect. Zero disables caching.
+	CacheTTL time.Duration
+	// BreakerThreshold is the number of consecutive failed scrapes after
+	// which scraping is suspended for BreakerCooldown. Zero disables the
+	// circuit breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// Retries is how often a request failing with a connection error or a 5xx
+	// status is repeated within a scrape, waiting RetryBackoff before the
+	// first retry and twice as long before each further one.
+	Retries      int
+	RetryBackoff time.Duration
+	// SmoothingWindow is the number of scrapes icecast_listeners_moving_average
+	// is averaged over. Zero disables it.
+	SmoothingWindow int
+	// ExpectedBitrate is the bitrate in kbit/s below which sources are
+	// counted in icecast_sources_below_expected_bitrate. Zero disables it.
+	ExpectedBitrate float64
+	// TimestampOnError is "nan", "zero" or "skip", see Exporter.timestamp.
+	TimestampOnError string
+	// AlsoScrapeAdmin merges /admin/stats into the status, see mergeAdminStats.
+	AlsoScrapeAdmin bool
+	// ListClients enables the connected time of listeners from
+	// /admin/listclients. GeoIP implies it.
+	ListClients bool
+	// ListMounts exports the mount points from /admin/listmounts, including
+	// hidden ones.
+	ListMounts bool
+	// GeoIP enables listeners by country from /admin/listclients.
+	GeoIP *geoip2.Reader
+	// ExpectedMounts are mount points for which icecast_source_up is
+	// exported, even while they're missing from the status.
+	ExpectedMounts []string
+	// MountLabels maps mount points to static labels added to their metrics.
+	MountLabels map[string]prometheus.Labels
+	// DisableExporterMetrics omits the icecast_exporter_* metrics.
+	DisableExporterMetrics bool
+}
+
+// jitter returns d randomly adjusted by up to ±10%, so that the caches of
+// several exporters don't expire in lockstep.
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()*0.2-0.1)*float64(d))
 }
 
+// TargetStatus describes the outcome of the last scrape of an Icecast server.
+type TargetStatus struct {
+	URI        string    `json:"uri"`
+	LastScrape time.Time `json:"last_scrape"`
+	Up         bool      `json:"up"`
+	LastError  string    `json:"last_error,omitempty"`
+}
 
 // Exporter collects Icecast stats from the given URI and exports them using
 // the prometheus metrics package.
@@ -87,16 +462,150 @@ type Exporter struct {
 
 	up                              prometheus.Gauge
 	totalScrapes, jsonParseFailures prometheus.Counter
+	scrapeErrors                    *prometheus.CounterVec
+	scrapeDuration                  prometheus.Histogram
+	scrapeBodyBytes                 prometheus.Gauge
+	scrapeRedirected                prometheus.Gauge
+	scrapeStatusCode                prometheus.Gauge
+	scrapeContentType               *prometheus.GaugeVec
+	timeoutSeconds                  prometheus.Gauge
+	cacheHits, cacheMisses          prometheus.Counter
+	retriesTotal                    prometheus.Counter
+	sourcesSeen                     prometheus.Counter
+	singleSourceFallback            prometheus.Gauge
 	serverStart                     prometheus.Gauge
+	serverUptime                    prometheus.Gauge
+	serverInfo                      *prometheus.GaugeVec
+	fileConnections                 prometheus.Gauge
+	listenerPeak                    prometheus.Gauge
+	listenersTotal                  prometheus.Gauge
+	activeSources                   prometheus.Gauge
+	averageBitrate                  prometheus.Gauge
+	bitrate                         *prometheus.GaugeVec
+	samplerate                      *prometheus.GaugeVec
+	channels                        *prometheus.GaugeVec
+	metadataInfo                    *prometheus.GaugeVec
+	sourceInfo                      *prometheus.GaugeVec
+	serverTypeCount                 prometheus.Gauge
+	sourcesByType                   *prometheus.GaugeVec
+	sourcesFutureStart              prometheus.Gauge
+	sourcesBelowBitrate             prometheus.Gauge
 	listeners                       *prometheus.GaugeVec
 	streamStart                     *prometheus.GaugeVec
+	queueSize                       *prometheus.GaugeVec
+	burstSize                       *prometheus.GaugeVec
+	maxListeners                    *prometheus.GaugeVec
+	ypListed                        *prometheus.GaugeVec
+	slowListeners                   *prometheus.GaugeVec
+	incomingBitrate                 *prometheus.GaugeVec
+	outgoingBitrate                 *prometheus.GaugeVec
+	bannedIPs                       prometheus.Gauge
+	listenerConnections             *prometheus.Desc
+	bytesRead, bytesSent            *prometheus.Desc
+	clientConnections               *prometheus.Desc
+	connections                     *prometheus.Desc
+	serverListenerConnections       *prometheus.Desc
+	sourceClientConnections         *prometheus.Desc
+	statsConnections                *prometheus.Desc
+	listenersByCountry              *prometheus.GaugeVec
+	listenerConnected               *prometheus.Desc
+	uniqueListeners                 *prometheus.Desc
+	listenersByPlayer               *prometheus.GaugeVec
+	listenersMovingAverage          *prometheus.GaugeVec
+	sourceListenerPeak              *prometheus.GaugeVec
+	sourceUp                        *prometheus.GaugeVec
+	mountListeners                  *prometheus.GaugeVec
+	mountConnected                  *prometheus.GaugeVec
+	listenerUtilization             *prometheus.GaugeVec
 	client                          *http.Client
+	requestTimeout                  time.Duration
+	bearerToken                     string
+	username                        string
+	password                        string
+	auth                            string
+	credentialHelper                *credentialHelper
+	headers                         http.Header
+	userAgent                       string
+
+	lastStatus  *IcecastStatus
+	cacheTTL    time.Duration
+	cacheExpiry time.Time
+
+	breakerThreshold    int
+	breakerCooldown     time.Duration
+	retries             int
+	retryBackoff        time.Duration
+	consecutiveFailures int
+	lastAttempt         time.Time
+
+	target TargetStatus
+
+	mountLabels     map[string]prometheus.Labels
+	extraLabelNames []string
+
+	disableExporterMetrics bool
+
+	listClients bool
+	listMounts  bool
+	geoip       *geoip2.Reader
+	scrapeAdmin bool
+
+	timestampOnError string
+	serverStartValid bool
+
+	errorLog logThrottle
+
+	// seenSources holds every listenurl collected so far.
+	seenSources map[string]bool
+
+	// listenerHistory holds the listeners of the last smoothingWindow
+	// scrapes per listenurl.
+	smoothingWindow int
+	listenerHistory map[string][]int
+
+	expectedBitrate float64
+	expectedMounts  []string
 }
 
 // NewExporter returns an initialized Exporter.
-func NewExporter(uri string, timeout time.Duration) *Exporter {
-	return &Exporter{
-		URI: uri,
+func NewExporter(uri string, opts Options) *Exporter {
+	extraLabelNames := mountLabelNames(opts.MountLabels)
+	sourceLabelNames := append(append([]string{}, labelNames...), extraLabelNames...)
+
+	e := &Exporter{
+		URI:              uri,
+		requestTimeout:   opts.RequestTimeout,
+		bearerToken:      opts.BearerToken,
+		username:         opts.Username,
+		password:         opts.Password,
+		auth:             opts.Auth,
+		credentialHelper: newCredentialHelper(opts.CredentialCommand),
+		headers:          opts.Headers,
+		userAgent:        opts.UserAgent,
+		cacheTTL:         opts.CacheTTL,
+		breakerThreshold: opts.BreakerThreshold,
+		breakerCooldown:  opts.BreakerCooldown,
+		retries:          opts.Retries,
+		retryBackoff:     opts.RetryBackoff,
+		target:           TargetStatus{URI: redactURI(uri)},
+		errorLog:         logThrottle{interval: time.Minute},
+		seenSources:      map[string]bool{},
+		smoothingWindow:  opts.SmoothingWindow,
+		listenerHistory:  map[string][]int{},
+		expectedBitrate:  opts.ExpectedBitrate,
+		expectedMounts:   opts.ExpectedMounts,
+		mountLabels:      opts.MountLabels,
+		extraLabelNames:  extraLabelNames,
+
+		disableExporterMetrics: opts.DisableExporterMetrics,
+
+		listClients: opts.ListClients || opts.GeoIP != nil,
+		listMounts:  opts.ListMounts,
+		geoip:       opts.GeoIP,
+		scrapeAdmin: opts.AlsoScrapeAdmin,
+
+		timestampOnError: opts.TimestampOnError,
+		serverStartValid: true,
 		up: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "up",
@@ -112,158 +621,1912 @@ func NewExporter(uri string, timeout time.Duration) *Exporter {
 			Name:      "exporter_json_parse_failures",
 			Help:      "Number of errors while parsing JSON.",
 		}),
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_scrape_errors_total",
+			Help:      "Number of failed Icecast scrapes by reason: dns, connect, timeout, http_4xx, http_5xx, decode or other.",
+		}, []string{"reason"}),
+		scrapeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "exporter_scrape_duration_seconds",
+			Help:      "Duration of Icecast scrapes.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		scrapeBodyBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_scrape_body_bytes",
+			Help:      "Size of the last Icecast status response body in bytes.",
+		}),
+		scrapeRedirected: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_scrape_redirected",
+			Help:      "Whether the last Icecast status request was redirected.",
+		}),
+		scrapeStatusCode: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_scrape_http_status_code",
+			Help:      "HTTP status code of the last Icecast status response, 0 if there was none.",
+		}),
+		scrapeContentType: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_scrape_content_type_info",
+			Help:      "Content type of the last Icecast status response.",
+		}, []string{"content_type"}),
+		timeoutSeconds: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_timeout_seconds",
+			Help:      "Configured timeout for Icecast scrapes.",
+		}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_cache_hits_total",
+			Help:      "Number of collects served from the status cache.",
+		}),
+		retriesTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_scrape_retries_total",
+			Help:      "Number of Icecast requests repeated after a transient failure.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_cache_misses_total",
+			Help:      "Number of collects that scraped Icecast because the status cache was expired.",
+		}),
+		sourcesSeen: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exporter_sources_seen_total",
+			Help:      "Number of distinct listen URLs seen since the exporter started.",
+		}),
+		singleSourceFallback: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_single_source_fallback",
+			Help:      "Whether the last status had a single source object instead of a list of sources.",
+		}),
+		serverUptime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "server_uptime_seconds",
+			Help:      "Seconds since the Icecast server started, as of the last scrape.",
+		}),
 		serverStart: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "server_start",
 			Help:      "Timestamp of server startup.",
 		}),
+		serverInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "server_info",
+			Help:      "Information about the Icecast server, value is always 1.",
+		}, []string{"host", "location", "admin", "server_id", "software", "version"}),
+		fileConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "file_connections",
+			Help:      "The number of connections for static files served by Icecast.",
+		}),
+		listenersTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "listeners_total",
+			Help:      "The number of currently connected listeners of all sources.",
+		}),
+		activeSources: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_sources",
+			Help:      "The number of currently connected source clients.",
+		}),
+		listenerPeak: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "listener_peak_total",
+			Help:      "The highest number of concurrent listeners of the server, 0 if not reported.",
+		}),
+		averageBitrate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "average_bitrate",
+			Help:      "Average bitrate in kbit/s of the sources reporting one.",
+		}),
+		serverTypeCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "server_type_count",
+			Help:      "The number of distinct server types among the current sources.",
+		}),
+		sourcesByType: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "sources_by_type",
+			Help:      "The number of current sources by server type.",
+		}, []string{"server_type"}),
+		bitrate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "bitrate",
+			Help:      "Bitrate in kbit/s of the source, if it reports one.",
+		}, sourceLabelNames),
+		samplerate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "samplerate",
+			Help:      "Sample rate in Hz of the source, if it reports one.",
+		}, sourceLabelNames),
+		channels: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "channels",
+			Help:      "Number of audio channels of the source, if it reports them.",
+		}, sourceLabelNames),
+		metadataInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "stream_metadata_info",
+			Help:      "Title and artist currently playing on the source, value is always 1.",
+		}, append(sourceLabelNames[:len(sourceLabelNames):len(sourceLabelNames)], "title", "artist")),
+		sourceInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "source_info",
+			Help:      "Station name, description and genre set by the source, value is always 1.",
+		}, append(sourceLabelNames[:len(sourceLabelNames):len(sourceLabelNames)], "server_name", "server_description", "genre")),
+		sourcesBelowBitrate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "sources_below_expected_bitrate",
+			Help:      "The number of sources reporting a bitrate below -icecast.expected-bitrate.",
+		}),
+		sourcesFutureStart: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "sources_future_start",
+			Help:      "The number of sources whose stream start is in the future, which indicates clock skew.",
+		}),
 		listeners: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "listeners",
 			Help:      "The number of currently connected listeners.",
-		}, labelNames),
+		}, sourceLabelNames),
 		streamStart: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: namespace,
 			Name:      "stream_start",
 			Help:      "Timestamp of when the currently active source client connected to this mount point.",
-		}, labelNames),
-		client: &http.Client{
-			Transport: &http.Transport{
-				Dial: func(netw, addr string) (net.Conn, error) {
-					c, err := net.DialTimeout(netw, addr, timeout)
-					if err != nil {
-						return nil, err
-					}
-					if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
-						return nil, err
-					}
-					return c, nil
-				},
-			},
+		}, sourceLabelNames),
+		queueSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "source_queue_size_bytes",
+			Help:      "Size of the source's audio queue, only reported by Icecast-KH.",
+		}, sourceLabelNames),
+		burstSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "source_burst_size_bytes",
+			Help:      "Amount of data sent to new listeners at once, if reported.",
+		}, sourceLabelNames),
+		maxListeners: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "source_max_listeners",
+			Help:      "The listener limit of the mount, only for mounts with a limit.",
+		}, sourceLabelNames),
+		ypListed: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "source_yp_listed",
+			Help:      "Whether the source is listed in public YP directories.",
+		}, sourceLabelNames),
+		incomingBitrate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "source_incoming_kbitrate",
+			Help:      "Bitrate in kbit/s measured from the source client, only reported by Icecast-KH.",
+		}, sourceLabelNames),
+		outgoingBitrate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "source_outgoing_kbitrate",
+			Help:      "Bitrate in kbit/s sent to all listeners of the source, only reported by Icecast-KH.",
+		}, sourceLabelNames),
+		bannedIPs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "banned_ips",
+			Help:      "The number of banned IP addresses, only reported by Icecast-KH.",
+		}),
+		slowListeners: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "source_slow_listeners",
+			Help:      "The number of listeners that fell behind the source's queue.",
+		}, sourceLabelNames),
+		sourceUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "source_up",
+			Help:      "Whether a source is connected to the expected mount point.",
+		}, []string{"mount"}),
+		mountListeners: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "mount_listeners",
+			Help:      "The number of currently connected listeners of a mount point listed by /admin/listmounts.",
+		}, []string{"mount"}),
+		mountConnected: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "mount_connected_seconds",
+			Help:      "How long the source of a mount point listed by /admin/listmounts has been connected.",
+		}, []string{"mount"}),
+		sourceListenerPeak: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "listener_peak",
+			Help:      "The highest number of concurrent listeners since the source client connected.",
+		}, sourceLabelNames),
+		listenersMovingAverage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "listeners_moving_average",
+			Help:      "The average number of listeners over the last -icecast.smoothing-window scrapes.",
+		}, sourceLabelNames),
+		listenerConnected: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "listener_connected_seconds"),
+			"How long the currently connected listeners have been connected.",
+			sourceLabelNames, nil,
+		),
+		uniqueListeners: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "unique_listeners"),
+			"The number of distinct IP addresses of the currently connected listeners.",
+			sourceLabelNames, nil,
+		),
+		listenersByPlayer: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "listeners_by_player",
+			Help:      "The number of currently connected listeners by kind of player: vlc, browser, mobile_app, bot or other.",
+		}, append(sourceLabelNames[:len(sourceLabelNames):len(sourceLabelNames)], "player")),
+		listenersByCountry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "listeners_by_country",
+			Help:      "The number of currently connected listeners by ISO country code.",
+		}, append(sourceLabelNames[:len(sourceLabelNames):len(sourceLabelNames)], "country")),
+		listenerUtilization: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "listener_utilization_ratio",
+			Help:      "The number of currently connected listeners divided by the mount's listener limit, only for mounts with a limit.",
+		}, sourceLabelNames),
+		// Icecast resets listener_connections when the source reconnects,
+		// which rate() handles like any other counter reset.
+		listenerConnections: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "listener_connections_total"),
+			"Total number of listener connections since the source client connected.",
+			sourceLabelNames, nil,
+		),
+		bytesRead: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "source", "read_bytes_total"),
+			"Total number of bytes received from the source client.",
+			sourceLabelNames, nil,
+		),
+		bytesSent: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "source", "sent_bytes_total"),
+			"Total number of bytes sent to listeners of the source.",
+			sourceLabelNames, nil,
+		),
+		clientConnections: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "client_connections_total"),
+			"Total number of client connections since the server started.",
+			nil, nil,
+		),
+		connections: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "connections_total"),
+			"Total number of connections of any kind since the server started.",
+			nil, nil,
+		),
+		serverListenerConnections: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "server", "listener_connections_total"),
+			"Total number of listener connections since the server started.",
+			nil, nil,
+		),
+		sourceClientConnections: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "source_client_connections_total"),
+			"Total number of source client connections since the server started.",
+			nil, nil,
+		),
+		statsConnections: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "stats_connections_total"),
+			"Total number of stats client connections since the server started.",
+			nil, nil,
+		),
+		client: newHTTPClient(opts),
+	}
+	e.timeoutSeconds.Set(opts.RequestTimeout.Seconds())
+	return e
+}
+
+// newHTTPClient returns the client used for scraping Icecast. Its transport
+// keeps connections alive between scrapes; only connecting is limited by a
+// timeout here, whole requests are limited by their context, see get.
+func newHTTPClient(opts Options) *http.Client {
+	dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+	if opts.SourceAddress != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: opts.SourceAddress}
+	}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         ipProtocolDialer(dialer.DialContext, opts.IPProtocol, opts.IPProtocolFallback),
+		TLSClientConfig:     opts.TLSConfig,
+		TLSHandshakeTimeout: opts.TLSHandshakeTimeout,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	if u := opts.ProxyURL; u != nil {
+		switch u.Scheme {
+		case "socks5":
+			var auth *proxy.Auth
+			if u.User != nil {
+				password, _ := u.User.Password()
+				auth = &proxy.Auth{User: u.User.Username(), Password: password}
+			}
+			// proxy.SOCKS5 never fails and its dialer supports contexts.
+			socks, _ := proxy.SOCKS5("tcp", u.Host, auth, dialer)
+			transport.Proxy = nil
+			transport.DialContext = socks.(proxy.ContextDialer).DialContext
+		default:
+			transport.Proxy = http.ProxyURL(u)
+		}
+	}
+	if opts.UnixSocket != "" {
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", opts.UnixSocket)
+		}
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return redirectError{location: redactURI(req.URL.String()), max: opts.MaxRedirects}
+			}
+			return nil
 		},
 	}
+	if opts.Username != "" && opts.Auth == "digest" {
+		client.Transport = &digestTransport{
+			username: opts.Username,
+			password: opts.Password,
+			next:     transport,
+		}
+	}
+	return client
+}
+
+// redirectError is returned for redirects beyond Options.MaxRedirects, e.g. to
+// the login page of a captive portal.
+type redirectError struct {
+	location string
+	max      int
+}
+
+func (err redirectError) Error() string {
+	return fmt.Sprintf("redirect to %s not followed, -icecast.max-redirects is %d", err.location, err.max)
+}
+
+// ipProtocolDialer returns dial restricted to the IP version protocol, "ip4"
+// or "ip6", like the blackbox exporter's ip_protocol. With fallback, the other
+// version is tried if that fails, e.g. for dual-stack hosts that only serve
+// Icecast on one of them.
+func ipProtocolDialer(dial func(context.Context, string, string) (net.Conn, error), protocol string, fallback bool) func(context.Context, string, string) (net.Conn, error) {
+	var preferred, other string
+	switch protocol {
+	case "ip4":
+		preferred, other = "tcp4", "tcp6"
+	case "ip6":
+		preferred, other = "tcp6", "tcp4"
+	default:
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, preferred, addr)
+		if err != nil && fallback && ctx.Err() == nil {
+			return dial(ctx, other, addr)
+		}
+		return conn, err
+	}
+}
+
+// parseProxyURL parses an HTTP, HTTPS or SOCKS5 proxy URL.
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q, expected http, https or socks5", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("host is required")
+	}
+	return u, nil
+}
+
+// Reload replaces the HTTP client and credentials used for scraping with ones
+// built from opts. All metrics are preserved, so counters don't reset.
+func (e *Exporter) Reload(opts Options) {
+	client := newHTTPClient(opts)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.client.CloseIdleConnections()
+	e.client = client
+	e.requestTimeout = opts.RequestTimeout
+	e.timeoutSeconds.Set(opts.RequestTimeout.Seconds())
+	e.bearerToken = opts.BearerToken
+	e.username = opts.Username
+	e.password = opts.Password
+	e.auth = opts.Auth
+	e.credentialHelper = newCredentialHelper(opts.CredentialCommand)
+	e.headers = opts.Headers
+	e.userAgent = opts.UserAgent
+}
+
+// Target returns the outcome of the last scrape.
+func (e *Exporter) Target() TargetStatus {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	return e.target
+}
+
+// LastStatus returns the status of the last successful scrape, or nil.
+func (e *Exporter) LastStatus() *IcecastStatus {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	return e.lastStatus
+}
+
+// sourceLabels returns the label values for the metrics of source, including
+// the static labels configured for its mount point.
+func (e *Exporter) sourceLabels(source IcecastStatusSource) []string {
+	labels := []string{source.Listenurl, source.ServerType}
+	if len(e.extraLabelNames) == 0 {
+		return labels
+	}
+	extra := e.mountLabels[mountOf(source.Listenurl)]
+	for _, name := range e.extraLabelNames {
+		labels = append(labels, extra[name])
+	}
+	return labels
+}
+
+// mountOf returns the mount point of a listen URL.
+func mountOf(listenurl string) string {
+	u, err := url.Parse(listenurl)
+	if err != nil {
+		return listenurl
+	}
+	return u.Path
+}
+
+// breakerOpen reports whether scraping is suspended after too many consecutive
+// failures. Once the cooldown has passed a single scrape is attempted again,
+// which closes the breaker on success and reopens it on failure.
+func (e *Exporter) breakerOpen() bool {
+	return e.breakerThreshold > 0 &&
+		e.consecutiveFailures >= e.breakerThreshold &&
+		time.Since(e.lastAttempt) < e.breakerCooldown
+}
+
+// sendCounter sends value as a counter of desc to ch, unless Icecast didn't
+// report it.
+func sendCounter(ch chan<- prometheus.Metric, desc *prometheus.Desc, value *int, labelValues ...string) {
+	if value != nil {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(*value), labelValues...)
+	}
+}
+
+// recordListeners adds the listeners of the sources in s to listenerHistory,
+// keeping the last smoothingWindow values per listenurl. Sources missing
+// from s are dropped, so a mount that comes back starts over.
+func (e *Exporter) recordListeners(s *IcecastStatus) {
+	history := make(map[string][]int, len(s.Icestats.Source))
+	for _, source := range s.Icestats.Source {
+		h := append(e.listenerHistory[source.Listenurl], source.Listeners)
+		if len(h) > e.smoothingWindow {
+			h = h[len(h)-e.smoothingWindow:]
+		}
+		history[source.Listenurl] = h
+	}
+	e.listenerHistory = history
+}
+
+// logThrottle rate-limits a repeated log message, so an Icecast server that is
+// down for hours doesn't flood the log with the same error on every scrape.
+type logThrottle struct {
+	interval   time.Duration
+	last       string
+	lastLogged time.Time
+	suppressed int
+}
+
+// allow reports whether msg should be logged, which it should if it differs
+// from the last message or the interval has passed, and how many identical
+// messages were suppressed since it was last logged.
+func (t *logThrottle) allow(msg string) (bool, int) {
+	now := time.Now()
+	if msg == t.last && now.Sub(t.lastLogged) < t.interval {
+		t.suppressed++
+		return false, 0
+	}
+	suppressed := t.suppressed
+	if msg != t.last {
+		suppressed = 0
+	}
+	t.last, t.lastLogged, t.suppressed = msg, now, 0
+	return true, suppressed
+}
+
+// reset makes the next message be logged, e.g. once Icecast is back up.
+func (t *logThrottle) reset() {
+	t.last, t.suppressed = "", 0
+}
+
+// exporterMetrics returns the metrics about the exporter itself, unless they
+// are disabled.
+func (e *Exporter) exporterMetrics() []prometheus.Collector {
+	if e.disableExporterMetrics {
+		return nil
+	}
+	return []prometheus.Collector{
+		e.totalScrapes,
+		e.jsonParseFailures,
+		e.scrapeErrors,
+		e.scrapeDuration,
+		e.scrapeBodyBytes,
+		e.scrapeRedirected,
+		e.scrapeStatusCode,
+		e.scrapeContentType,
+		e.timeoutSeconds,
+		e.cacheHits,
+		e.cacheMisses,
+		e.retriesTotal,
+		e.sourcesSeen,
+		e.singleSourceFallback,
+	}
 }
 
 // Describe describes all the metrics ever exported by the Icecast exporter. It
 // implements prometheus.Collector.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.up.Desc()
-	ch <- e.totalScrapes.Desc()
-	ch <- e.jsonParseFailures.Desc()
+	for _, c := range e.exporterMetrics() {
+		c.Describe(ch)
+	}
 	ch <- e.serverStart.Desc()
+	ch <- e.serverUptime.Desc()
+	e.serverInfo.Describe(ch)
+	ch <- e.fileConnections.Desc()
+	ch <- e.listenerPeak.Desc()
+	ch <- e.listenersTotal.Desc()
+	ch <- e.activeSources.Desc()
+	ch <- e.averageBitrate.Desc()
+	ch <- e.serverTypeCount.Desc()
+	e.sourcesByType.Describe(ch)
+	ch <- e.sourcesFutureStart.Desc()
+	if e.expectedBitrate > 0 {
+		ch <- e.sourcesBelowBitrate.Desc()
+	}
 	e.listeners.Describe(ch)
+	e.sourceListenerPeak.Describe(ch)
+	e.sourceUp.Describe(ch)
+	if e.listMounts {
+		e.mountListeners.Describe(ch)
+		e.mountConnected.Describe(ch)
+	}
+	if e.smoothingWindow > 0 {
+		e.listenersMovingAverage.Describe(ch)
+	}
 	e.streamStart.Describe(ch)
+	e.queueSize.Describe(ch)
+	e.burstSize.Describe(ch)
+	e.maxListeners.Describe(ch)
+	e.ypListed.Describe(ch)
+	e.bitrate.Describe(ch)
+	e.samplerate.Describe(ch)
+	e.channels.Describe(ch)
+	e.metadataInfo.Describe(ch)
+	e.sourceInfo.Describe(ch)
+	e.slowListeners.Describe(ch)
+	e.incomingBitrate.Describe(ch)
+	e.outgoingBitrate.Describe(ch)
+	ch <- e.bannedIPs.Desc()
+	e.listenerUtilization.Describe(ch)
+	if e.geoip != nil {
+		e.listenersByCountry.Describe(ch)
+	}
+	if e.listClients {
+		ch <- e.listenerConnected
+		ch <- e.uniqueListeners
+		e.listenersByPlayer.Describe(ch)
+	}
+	ch <- e.listenerConnections
+	ch <- e.bytesRead
+	ch <- e.bytesSent
+	ch <- e.clientConnections
+	ch <- e.connections
+	ch <- e.serverListenerConnections
+	ch <- e.sourceClientConnections
+	ch <- e.statsConnections
 }
 
 // Collect fetches the stats from configured Icecast location and delivers them
 // as Prometheus metrics. It implements prometheus.Collector.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	status := make(chan *IcecastStatus)
-	go e.scrape(status)
+	e.collect(context.Background(), ch)
+}
 
+// collect is like Collect, but aborts the Icecast scrape once ctx is done.
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
 	e.mutex.Lock() // To protect metrics from concurrent collects.
 	defer e.mutex.Unlock()
 
+	var s *IcecastStatus
+	switch {
+	case e.cacheTTL > 0 && time.Now().Before(e.cacheExpiry):
+		e.cacheHits.Inc()
+		s = e.lastStatus
+	case e.breakerOpen():
+		// Don't wait for a server that is known to be down, but keep
+		// exporting what we last got from it.
+		e.up.Set(0)
+		s = e.lastStatus
+	default:
+		if e.cacheTTL > 0 {
+			e.cacheMisses.Inc()
+		}
+		// Buffered, so scrape can never block on sending even if nobody
+		// receives anymore.
+		status := make(chan *IcecastStatus, 1)
+		go e.scrape(ctx, status)
+		s = <-status
+
+		e.lastAttempt = time.Now()
+		if s == nil {
+			e.consecutiveFailures++
+			break
+		}
+		e.consecutiveFailures = 0
+		e.lastStatus = s
+		if e.smoothingWindow > 0 {
+			e.recordListeners(s)
+		}
+		if e.cacheTTL > 0 {
+			e.cacheExpiry = time.Now().Add(jitter(e.cacheTTL))
+		}
+	}
+
+	e.serverInfo.Reset()
+	e.sourcesByType.Reset()
 	e.listeners.Reset()
+	e.sourceListenerPeak.Reset()
+	e.sourceUp.Reset()
+	e.mountListeners.Reset()
+	e.mountConnected.Reset()
+	e.listenersMovingAverage.Reset()
 	e.streamStart.Reset()
+	e.queueSize.Reset()
+	e.burstSize.Reset()
+	e.maxListeners.Reset()
+	e.ypListed.Reset()
+	e.bitrate.Reset()
+	e.samplerate.Reset()
+	e.channels.Reset()
+	e.metadataInfo.Reset()
+	e.sourceInfo.Reset()
+	e.slowListeners.Reset()
+	e.incomingBitrate.Reset()
+	e.outgoingBitrate.Reset()
+	e.listenerUtilization.Reset()
+	e.listenersByPlayer.Reset()
+	e.listenersByCountry.Reset()
+
+	for _, mount := range e.expectedMounts {
+		up := 0.0
+		if s != nil {
+			for _, source := range s.Icestats.Source {
+				if mountOf(source.Listenurl) == mount {
+					up = 1
+				}
+			}
+		}
+		e.sourceUp.WithLabelValues(mount).Set(up)
+	}
+	if s != nil {
+		for _, mount := range s.Icestats.Mounts {
+			e.mountListeners.WithLabelValues(mount.Mount).Set(float64(mount.Listeners))
+			e.mountConnected.WithLabelValues(mount.Mount).Set(float64(mount.Connected))
+		}
+	}
 
-	if s := <-status; s != nil {
-		e.serverStart.Set(float64(s.Icestats.ServerStart.Time().Unix()))
+	var bannedIPsValid bool
+	// Without a status, uptime would be frozen at the last scrape.
+	e.serverStartValid = false
+	if s != nil {
+		serverStart, ok := e.timestamp(s.Icestats.ServerStart.Time())
+		e.serverStart.Set(serverStart)
+		e.serverStartValid = ok
+		if start := s.Icestats.ServerStart.Time(); !start.IsZero() {
+			e.serverUptime.Set(time.Since(start).Seconds())
+		} else {
+			e.serverUptime.Set(serverStart)
+		}
+		software, version := parseServerID(s.Icestats.ServerID)
+		e.serverInfo.WithLabelValues(s.Icestats.Host, s.Icestats.Location, s.Icestats.Admin, s.Icestats.ServerID, software, version).Set(1)
+		e.fileConnections.Set(float64(s.Icestats.FileConnections))
+		sendCounter(ch, e.clientConnections, s.Icestats.ClientConnections)
+		sendCounter(ch, e.connections, s.Icestats.Connections)
+		sendCounter(ch, e.serverListenerConnections, s.Icestats.ListenerConnections)
+		sendCounter(ch, e.sourceClientConnections, s.Icestats.SourceClientConnections)
+		sendCounter(ch, e.statsConnections, s.Icestats.StatsConnections)
+		if s.Icestats.BannedIPs != nil {
+			e.bannedIPs.Set(float64(*s.Icestats.BannedIPs))
+			bannedIPsValid = true
+		}
+		e.listenerPeak.Set(float64(s.Icestats.ListenerPeak))
+		var bitrateSum float64
+		var bitrateSources int
+		serverTypes := map[string]int{}
+		var futureStart, belowBitrate, listeners int
+		now := time.Now()
 		for _, source := range s.Icestats.Source {
-			e.listeners.WithLabelValues(source.Listenurl, source.ServerType).Set(float64(source.Listeners))
-			e.streamStart.WithLabelValues(source.Listenurl, source.ServerType).Set(float64(source.StreamStart.Time().Unix()))
+			serverTypes[source.ServerType]++
+			if !e.seenSources[source.Listenurl] {
+				e.seenSources[source.Listenurl] = true
+				e.sourcesSeen.Inc()
+			}
+			if source.StreamStart.Time().After(now) {
+				futureStart++
+			}
+			labels := e.sourceLabels(source)
+			e.listeners.WithLabelValues(labels...).Set(float64(source.Listeners))
+			listeners += source.Listeners
+			if source.ListenerPeak != nil {
+				e.sourceListenerPeak.WithLabelValues(labels...).Set(float64(*source.ListenerPeak))
+			}
+			if history := e.listenerHistory[source.Listenurl]; len(history) > 0 {
+				var sum int
+				for _, listeners := range history {
+					sum += listeners
+				}
+				e.listenersMovingAverage.WithLabelValues(labels...).Set(float64(sum) / float64(len(history)))
+			}
+			if streamStart, ok := e.timestamp(source.StreamStart.Time()); ok {
+				e.streamStart.WithLabelValues(labels...).Set(streamStart)
+			}
+			e.queueSize.WithLabelValues(labels...).Set(float64(source.QueueSize))
+			ypListed := 0.0
+			if source.Public {
+				ypListed = 1
+			}
+			e.ypListed.WithLabelValues(labels...).Set(ypListed)
+			if source.SlowListeners != nil {
+				e.slowListeners.WithLabelValues(labels...).Set(float64(*source.SlowListeners))
+			}
+			if source.IncomingBitrate != nil {
+				e.incomingBitrate.WithLabelValues(labels...).Set(float64(*source.IncomingBitrate) / 1000)
+			}
+			if source.OutgoingKbitrate != nil {
+				e.outgoingBitrate.WithLabelValues(labels...).Set(float64(*source.OutgoingKbitrate))
+			}
+			if source.BurstSize != nil {
+				e.burstSize.WithLabelValues(labels...).Set(float64(*source.BurstSize))
+			}
+			if source.MaxListeners != nil && *source.MaxListeners > 0 {
+				e.maxListeners.WithLabelValues(labels...).Set(float64(*source.MaxListeners))
+				e.listenerUtilization.WithLabelValues(labels...).Set(float64(source.Listeners) / float64(*source.MaxListeners))
+			}
+			if e.listClients && source.Clients != nil {
+				count, sum, quantiles := connectedSummary(source.Clients)
+				ch <- prometheus.MustNewConstSummary(e.listenerConnected, count, sum, quantiles, labels...)
+				ch <- prometheus.MustNewConstMetric(e.uniqueListeners, prometheus.GaugeValue, float64(countUniqueIPs(source.Clients)), labels...)
+				for player, count := range countListenersByPlayer(source.Clients) {
+					e.listenersByPlayer.WithLabelValues(append(labels[:len(labels):len(labels)], player)...).Set(float64(count))
+				}
+			}
+			if e.geoip != nil {
+				for country, count := range e.countListenersByCountry(source.Clients) {
+					e.listenersByCountry.WithLabelValues(append(labels[:len(labels):len(labels)], country)...).Set(float64(count))
+				}
+			}
+			sendCounter(ch, e.listenerConnections, source.ListenerConnections, labels...)
+			if source.TotalBytesRead != nil {
+				ch <- prometheus.MustNewConstMetric(e.bytesRead, prometheus.CounterValue,
+					float64(*source.TotalBytesRead), labels...)
+			}
+			if source.TotalBytesSent != nil {
+				ch <- prometheus.MustNewConstMetric(e.bytesSent, prometheus.CounterValue,
+					float64(*source.TotalBytesSent), labels...)
+			}
+			if samplerate, ok := source.audioField("samplerate", source.AudioSamplerate, source.Samplerate); ok {
+				e.samplerate.WithLabelValues(labels...).Set(samplerate)
+			}
+			if channels, ok := source.audioField("channels", source.AudioChannels, source.Channels); ok {
+				e.channels.WithLabelValues(labels...).Set(channels)
+			}
+			if source.Title != "" || source.Artist != "" {
+				e.metadataInfo.WithLabelValues(append(labels[:len(labels):len(labels)], string(source.Title), string(source.Artist))...).Set(1)
+			}
+			e.sourceInfo.WithLabelValues(append(labels[:len(labels):len(labels)],
+				string(source.ServerName), string(source.ServerDescription), string(source.Genre))...).Set(1)
+			if bitrate, ok := source.bitrate(); ok {
+				e.bitrate.WithLabelValues(labels...).Set(bitrate)
+				bitrateSum += bitrate
+				bitrateSources++
+				if bitrate < e.expectedBitrate {
+					belowBitrate++
+				}
+			}
+		}
+		if bitrateSources > 0 {
+			e.averageBitrate.Set(bitrateSum / float64(bitrateSources))
+		} else {
+			e.averageBitrate.Set(0)
+		}
+		e.serverTypeCount.Set(float64(len(serverTypes)))
+		for serverType, count := range serverTypes {
+			e.sourcesByType.WithLabelValues(serverType).Set(float64(count))
+		}
+		e.sourcesFutureStart.Set(float64(futureStart))
+		if s.Icestats.Listeners != nil {
+			listeners = *s.Icestats.Listeners
 		}
+		e.listenersTotal.Set(float64(listeners))
+		sources := len(s.Icestats.Source)
+		if s.Icestats.Sources != nil {
+			sources = *s.Icestats.Sources
+		}
+		e.activeSources.Set(float64(sources))
+		e.sourcesBelowBitrate.Set(float64(belowBitrate))
 	}
 
 	ch <- e.up
-	ch <- e.totalScrapes
-	ch <- e.jsonParseFailures
-	ch <- e.serverStart
+	for _, c := range e.exporterMetrics() {
+		c.Collect(ch)
+	}
+	if e.serverStartValid {
+		ch <- e.serverStart
+		ch <- e.serverUptime
+	}
+	e.serverInfo.Collect(ch)
+	// Without a status, these would keep the values of the last successful
+	// scrape, so they disappear like the per-mount series do.
+	if s != nil {
+		ch <- e.fileConnections
+		ch <- e.listenerPeak
+		ch <- e.averageBitrate
+		ch <- e.serverTypeCount
+		ch <- e.sourcesFutureStart
+		if e.expectedBitrate > 0 {
+			ch <- e.sourcesBelowBitrate
+		}
+		ch <- e.listenersTotal
+		ch <- e.activeSources
+	}
+	e.sourcesByType.Collect(ch)
 	e.listeners.Collect(ch)
+	e.sourceListenerPeak.Collect(ch)
+	e.sourceUp.Collect(ch)
+	if e.listMounts {
+		e.mountListeners.Collect(ch)
+		e.mountConnected.Collect(ch)
+	}
+	if e.smoothingWindow > 0 {
+		e.listenersMovingAverage.Collect(ch)
+	}
 	e.streamStart.Collect(ch)
-}
+	e.queueSize.Collect(ch)
+	e.burstSize.Collect(ch)
+	e.maxListeners.Collect(ch)
+	e.ypListed.Collect(ch)
+	e.bitrate.Collect(ch)
+	e.samplerate.Collect(ch)
+	e.channels.Collect(ch)
+	e.metadataInfo.Collect(ch)
+	e.sourceInfo.Collect(ch)
+	e.slowListeners.Collect(ch)
+	e.incomingBitrate.Collect(ch)
+	e.outgoingBitrate.Collect(ch)
+	if bannedIPsValid {
+		ch <- e.bannedIPs
+	}
+	e.listenerUtilization.Collect(ch)
+	if e.listClients {
+		e.listenersByPlayer.Collect(ch)
+	}
+	if e.geoip != nil {
+		e.listenersByCountry.Collect(ch)
+	}
+}
 
-func (e *Exporter) scrape(status chan<- *IcecastStatus) {
-	defer close(status)
+func (e *Exporter) scrape(ctx context.Context, status chan<- *IcecastStatus) {
+	var (
+		s   *IcecastStatus
+		err error
+	)
+	// Deferred first so it runs last: collect holds the lock protecting
+	// e.target only until it receives the status.
+	defer func() {
+		status <- s
+	}()
 
 	e.totalScrapes.Inc()
 
-	resp, err := e.client.Get(e.URI)
+	// The admin pages are requested one after another while collect holds
+	// the lock, so they share the deadline of the scrape.
+	if e.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.requestTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	defer func() {
+		e.scrapeDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	defer func() {
+		e.target.LastScrape = start
+		// Like icecast_up, a status that can't be parsed still means that
+		// Icecast is up.
+		var decodeErr decodeError
+		e.target.Up = err == nil || errors.As(err, &decodeErr)
+		e.target.LastError = ""
+		if err != nil {
+			e.target.LastError = err.Error()
+		}
+	}()
+
+	s, resp, err := e.fetch(ctx)
+	var redirectErr redirectError
+	if resp != nil && resp.redirected || errors.As(err, &redirectErr) {
+		e.scrapeRedirected.Set(1)
+	} else {
+		e.scrapeRedirected.Set(0)
+	}
+	e.scrapeStatusCode.Set(0)
+	e.scrapeContentType.Reset()
+	e.scrapeBodyBytes.Set(0)
+	if resp != nil {
+		e.scrapeStatusCode.Set(float64(resp.statusCode))
+		if resp.contentType != "" {
+			e.scrapeContentType.WithLabelValues(resp.contentType).Set(1)
+		}
+		e.scrapeBodyBytes.Set(float64(resp.bodyBytes))
+	}
+	var decodeErr decodeError
+	if errors.As(err, &decodeErr) {
+		// Icecast answered, just not with a status that could be parsed.
+		e.up.Set(1)
+		e.errorLog.reset()
+		log.Errorf("Can't read JSON: %v", err)
+		e.jsonParseFailures.Inc()
+		e.scrapeErrors.WithLabelValues("decode").Inc()
+		return
+	}
 	if err != nil {
+		reason := errorReason(err)
 		e.up.Set(0)
-		log.Errorf("Can't scrape Icecast: %v", err)
+		e.scrapeErrors.WithLabelValues(reason).Inc()
+		if ok, suppressed := e.errorLog.allow(err.Error()); ok {
+			l := log.With("reason", reason)
+			if suppressed > 0 {
+				l = l.With("suppressed", suppressed)
+			}
+			l.Errorf("Can't scrape Icecast: %v", err)
+		}
 		return
 	}
-	defer resp.Body.Close()
 	e.up.Set(1)
-	
-	// Copy response body into intermediate buffer,
-	// so we can deserialize twice
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	e.errorLog.reset()
+
+	if s.singleSource() {
+		e.singleSourceFallback.Set(1)
+	} else {
+		e.singleSourceFallback.Set(0)
+	}
+
+	if e.scrapeAdmin {
+		if err := e.mergeAdminStats(ctx, s); err != nil {
+			log.Errorf("Can't scrape Icecast admin stats: %v", err)
+		}
+	}
+	if e.listClients {
+		e.addListeners(ctx, s)
+	}
+	if e.listMounts {
+		mounts, err := e.fetchMounts(ctx)
+		if err != nil {
+			log.Errorf("Can't list Icecast mounts: %v", err)
+		}
+		s.Icestats.Mounts = mounts
+	}
+}
+
+// fetch fetches and decodes the Icecast status document, along with the
+// response it came in. A file:// scrape URI is read from disk, e.g. for
+// testing alerting rules offline.
+func (e *Exporter) fetch(ctx context.Context) (*IcecastStatus, *response, error) {
+	var s *IcecastStatus
+	decode := func(r io.Reader) (err error) {
+		s, err = parseStatus(r)
+		return err
+	}
+
+	if u, err := url.Parse(e.URI); err == nil && u.Scheme == "file" {
+		f, err := os.Open(u.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		body := &countingReader{r: f}
+		if err := decode(body); err != nil {
+			if body.err != nil {
+				return nil, nil, body.err
+			}
+			return nil, &response{bodyBytes: body.n}, decodeError{err}
+		}
+		return s, &response{bodyBytes: body.n}, nil
+	}
+
+	resp, err := e.getResponse(ctx, e.URI, decode)
 	if err != nil {
-		e.up.Set(0)
-		log.Errorf("Can't ready response body: %v", err)
-		return
+		return nil, resp, err
+	}
+	return s, resp, nil
+}
+
+// response is what a request to Icecast returned. Files read instead have
+// neither status code nor content type.
+type response struct {
+	bodyBytes   int64
+	statusCode  int
+	contentType string
+	// redirected is set if the body was served from another URI.
+	redirected bool
+}
+
+// countingReader counts the bytes read from r and keeps the first error other
+// than io.EOF, to tell failed reads from bodies that fail to decode.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// decodeError is returned if a response was received but its body couldn't be
+// decoded. It doesn't unwrap, so that e.g. the io.ErrUnexpectedEOF of a
+// truncated document isn't mistaken for a transient connection error.
+type decodeError struct {
+	err error
+}
+
+func (err decodeError) Error() string {
+	return err.err.Error()
+}
+
+// get requests uri from Icecast with the configured credentials and headers
+// and returns the response body.
+func (e *Exporter) get(ctx context.Context, uri string) ([]byte, error) {
+	var body []byte
+	_, err := e.getResponse(ctx, uri, func(r io.Reader) (err error) {
+		body, err = ioutil.ReadAll(r)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	
-	buf := bytes.NewBuffer(bodyBytes)
+	return body, nil
+}
+
+// getResponse requests uri and passes the body of a successful response to
+// read as it arrives. It returns the response, also along with the error for
+// a failed HTTP status. Transient failures are retried, all within the
+// request timeout.
+func (e *Exporter) getResponse(ctx context.Context, uri string, read func(io.Reader) error) (*response, error) {
+	if e.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.requestTimeout)
+		defer cancel()
+	}
+
+	backoff := e.retryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := e.getOnce(ctx, uri, read)
+		if err == nil || attempt >= e.retries || !retryable(err) {
+			return resp, err
+		}
+		select {
+		case <-ctx.Done():
+			return resp, err
+		case <-time.After(backoff):
+		}
+		e.retriesTotal.Inc()
+		backoff *= 2
+	}
+}
+
+// statusError is returned for responses with a status other than 2xx, so error
+// pages aren't mistaken for the status document.
+type statusError struct {
+	code int
+}
+
+func (err statusError) Error() string {
+	return fmt.Sprintf("HTTP status %d %s", err.code, http.StatusText(err.code))
+}
+
+// retryable returns whether err might not occur again, e.g. while Icecast
+// restarts during log rotation.
+func retryable(err error) bool {
+	var statusErr statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// getOnce is a single attempt of getResponse.
+func (e *Exporter) getOnce(ctx context.Context, uri string, read func(io.Reader) error) (*response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range e.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	if e.userAgent != "" && req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", e.userAgent)
+	}
+	if e.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+e.bearerToken)
+	}
+	// Digest authentication is done by the transport, see newHTTPClient.
+	if e.username != "" && e.auth != "digest" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+	if e.credentialHelper != nil {
+		if err := e.credentialHelper.authorize(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body := &countingReader{r: resp.Body}
+	r := &response{
+		statusCode:  resp.StatusCode,
+		contentType: resp.Header.Get("Content-Type"),
+		redirected:  resp.Request.URL.String() != uri,
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Error pages aren't decoded, but read so their size is known.
+		io.Copy(ioutil.Discard, body)
+		r.bodyBytes = body.n
+		return r, statusError{resp.StatusCode}
+	}
+	err = read(body)
+	r.bodyBytes = body.n
+	if body.err != nil {
+		return nil, body.err
+	}
+	if err != nil {
+		return r, decodeError{err}
+	}
+	return r, nil
+}
+
+// Check scrapes Icecast once without updating any metrics and returns an
+// error if the status can't be fetched or parsed.
+func (e *Exporter) Check(ctx context.Context) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	_, _, err := e.fetch(ctx)
+	return err
+}
+
+// errorReason classifies a failed request as "dns", "timeout", "connect",
+// "http_4xx", "http_5xx" or "other".
+func errorReason(err error) string {
+	var statusErr statusError
+	if errors.As(err, &statusErr) {
+		switch {
+		case statusErr.code >= 500:
+			return "http_5xx"
+		case statusErr.code >= 400:
+			return "http_4xx"
+		}
+		return "other"
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return "connect"
+	}
+	return "other"
+}
+
+// parseStatus decodes the JSON status document served by Icecast from r.
+func parseStatus(r io.Reader) (*IcecastStatus, error) {
+	// Some proxies prepend a UTF-8 byte order mark, which isn't valid JSON.
+	br := bufio.NewReader(r)
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		br.Discard(len(bom))
+	}
+
 	var s IcecastStatus
-	err = json.NewDecoder(buf).Decode(&s)
+	if err := decodeJSON(br, &s); err != nil {
+		return nil, err
+	}
 
+	// Older Icecast versions lack the *_iso8601 fields.
+	if s.Icestats.ServerStart.Time().IsZero() {
+		s.Icestats.ServerStart = s.Icestats.ServerStartText
+	}
+	for i := range s.Icestats.Source {
+		if source := &s.Icestats.Source[i]; source.StreamStart.Time().IsZero() {
+			source.StreamStart = source.StreamStartText
+		}
+	}
+	return &s, nil
+}
+
+// decodeJSON decodes the first JSON document read from r into v. Anything but
+// whitespace after it, e.g. a second document appended by a proxy, is logged
+// and ignored.
+func decodeJSON(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	rest, err := ioutil.ReadAll(io.MultiReader(dec.Buffered(), r))
 	if err != nil {
-		// If only a single stream is active, the JSON will
-		// have a different format with "source" being an object
-		buf := bytes.NewBuffer(bodyBytes)
-		var s2 IcecastStatusSingle
-		err = json.NewDecoder(buf).Decode(&s2)
+		return err
+	}
+	if rest = bytes.TrimSpace(rest); len(rest) > 0 {
+		log.Warnf("Ignoring %d bytes of trailing data after the JSON status", len(rest))
+	}
+	return nil
+}
+
+// contextCollector binds an Exporter to the context of a single metrics request,
+// so that the Icecast scrape is aborted when the request is cancelled.
+type contextCollector struct {
+	ctx      context.Context
+	exporter *Exporter
+}
+
+func (c contextCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.exporter.Describe(ch)
+}
+
+func (c contextCollector) Collect(ch chan<- prometheus.Metric) {
+	c.exporter.collect(c.ctx, ch)
+}
+
+// metricsHandler returns a handler serving the metrics of gatherer along with
+// those of exporters, which scrape Icecast using the context of each request.
+// exporters maps server names, which are added as server label unless empty,
+// to exporters, and relays maps them to the comparison with their master.
+func metricsHandler(exporters map[string]*Exporter, relays map[string]relayCollector, gatherer prometheus.Gatherer, timeoutOffset time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := scrapeContext(r, timeoutOffset)
+		defer cancel()
+		registry := prometheus.NewRegistry()
+		for name, exporter := range exporters {
+			var registerer prometheus.Registerer = registry
+			if name != "" {
+				registerer = prometheus.WrapRegistererWith(prometheus.Labels{"server": name}, registry)
+			}
+			registerer.MustRegister(contextCollector{ctx: ctx, exporter: exporter})
+			if relay, ok := relays[name]; ok {
+				registerer.MustRegister(relay)
+			}
+		}
+		promhttp.HandlerFor(prometheus.Gatherers{gatherer, registry}, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// probeHandler returns a handler that scrapes the Icecast server given by the
+// target parameter, like the blackbox exporter, so one exporter can serve
+// many servers. The metrics get a target label with the parameter's value.
+func probeHandler(opts func() Options, timeoutOffset time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+			return
+		}
+		uri := target
+		if !strings.Contains(uri, "://") {
+			uri = "http://" + uri
+		}
+		uri, err := normalizeScrapeURI(uri)
+		if err == nil && !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+			err = fmt.Errorf("only http and https are supported")
+		}
 		if err != nil {
-			log.Errorf("Can't read JSON: %v", err)
-			e.jsonParseFailures.Inc()
+			http.Error(w, fmt.Sprintf("invalid target %q: %v", target, err), http.StatusBadRequest)
 			return
 		}
-		
-		// Copy over to staus object
-		s.Icestats.ServerStart = s2.Icestats.ServerStart
-		s.Icestats.Source = []IcecastStatusSource{s2.Icestats.Source}
+
+		exporter := NewExporter(uri, probeOptions(opts()))
+		// Each probe has its own client, whose connections nobody reuses.
+		defer exporter.client.CloseIdleConnections()
+		ctx, cancel := scrapeContext(r, timeoutOffset)
+		defer cancel()
+		registry := prometheus.NewRegistry()
+		labeled := prometheus.WrapRegistererWith(prometheus.Labels{"target": target}, registry)
+		if err := labeled.Register(contextCollector{ctx: ctx, exporter: exporter}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// probeOptions returns opts without anything that belongs to the configured
+// Icecast servers rather than to an arbitrary target: credentials, headers,
+// the TLS client certificate and the Unix socket. The admin pages need the
+// credentials, so they aren't requested either.
+func probeOptions(opts Options) Options {
+	opts.BearerToken = ""
+	opts.Username = ""
+	opts.Password = ""
+	opts.CredentialCommand = ""
+	opts.Headers = nil
+	opts.UnixSocket = ""
+	if opts.TLSConfig != nil {
+		opts.TLSConfig = opts.TLSConfig.Clone()
+		opts.TLSConfig.Certificates = nil
+		opts.TLSConfig.GetClientCertificate = nil
+	}
+	opts.AlsoScrapeAdmin = false
+	opts.ListClients = false
+	opts.ListMounts = false
+	opts.GeoIP = nil
+	return opts
+}
+
+// scrapeContext returns the context of r, limited to the scrape timeout that
+// Prometheus sends in X-Prometheus-Scrape-Timeout-Seconds minus offset, so the
+// exporter answers before Prometheus gives up.
+func scrapeContext(r *http.Request, offset time.Duration) (context.Context, context.CancelFunc) {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return context.WithCancel(r.Context())
 	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > offset {
+		timeout -= offset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
 
-	status <- &s
+// targetsHandler returns a handler serving the outcome of the last scrape of
+// each exporter as JSON.
+func targetsHandler(exporters ...*Exporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets := make([]TargetStatus, 0, len(exporters))
+		for _, e := range exporters {
+			targets = append(targets, e.Target())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(targets); err != nil {
+			log.Errorf("Can't encode targets: %v", err)
+		}
+	})
+}
+
+// redactURI returns uri with any password replaced by "xxxxx".
+func redactURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	return u.Redacted()
+}
+
+// normalizeScrapeURI appends the default status path to uri if it has none and
+// warns if the path doesn't look like a JSON status endpoint.
+func normalizeScrapeURI(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "file" {
+		if u.Path == "" {
+			return "", fmt.Errorf("path is required")
+		}
+		return u.String(), nil
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("scheme and host are required")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = defaultStatusPath
+	} else if !strings.Contains(path.Base(u.Path), "json") {
+		log.Warnf("Scrape URI path %q doesn't look like a JSON status endpoint, Icecast serves it at %s", u.Path, defaultStatusPath)
+	}
+	return u.String(), nil
+}
+
+// defaultUserAgent returns "icecast_exporter/<version>", or just
+// "icecast_exporter" for builds without version information, like a plain
+// "go get".
+func defaultUserAgent(version string) string {
+	if version == "" {
+		return "icecast_exporter"
+	}
+	return "icecast_exporter/" + version
+}
+
+// parseMountLabels parses a mapping like "/jazz=channel:JazzFM,/rock=channel:RockFM"
+// of mount points to static labels. A mount point may be given several times
+// to set more than one label.
+func parseMountLabels(mapping string) (map[string]prometheus.Labels, error) {
+	mountLabels := map[string]prometheus.Labels{}
+	if mapping == "" {
+		return mountLabels, nil
+	}
+	for _, entry := range strings.Split(mapping, ",") {
+		eq := strings.Index(entry, "=")
+		colon := strings.Index(entry, ":")
+		if eq < 1 || colon < eq {
+			return nil, fmt.Errorf("expected \"/mount=name:value\", got %q", entry)
+		}
+		mount, name, value := entry[:eq], entry[eq+1:colon], entry[colon+1:]
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+		for _, reserved := range append(append([]string{}, labelNames...), reservedLabelNames...) {
+			if name == reserved {
+				return nil, fmt.Errorf("label name %q is reserved", name)
+			}
+		}
+		if mountLabels[mount] == nil {
+			mountLabels[mount] = prometheus.Labels{}
+		}
+		mountLabels[mount][name] = value
+	}
+	return mountLabels, nil
+}
+
+// mountLabelNames returns the sorted names of all labels in mountLabels.
+func mountLabelNames(mountLabels map[string]prometheus.Labels) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, labels := range mountLabels {
+		for name := range labels {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// headerFlag collects repeated "Name: Value" flags into an http.Header.
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var headers []string
+	for name, values := range h {
+		for _, value := range values {
+			headers = append(headers, name+": "+value)
+		}
+	}
+	return strings.Join(headers, ", ")
+}
+
+func (h headerFlag) Set(header string) error {
+	i := strings.Index(header, ":")
+	if i < 1 || strings.ContainsAny(header[:i], " \t") {
+		return fmt.Errorf("expected \"Name: Value\", got %q", header)
+	}
+	http.Header(h).Add(header[:i], strings.TrimSpace(header[i+1:]))
+	return nil
+}
+
+// readSecretFile returns the contents of filename with surrounding whitespace
+// removed.
+func readSecretFile(filename string) (string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
 }
 
 func main() {
 	var (
-		listenAddress    = flag.String("web.listen-address", ":9146", "Address to listen on for web interface and telemetry.")
-		metricsPath      = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
-		icecastScrapeURI = flag.String("icecast.scrape-uri", "http://localhost:8000/status-json.xsl", "URI on which to scrape Icecast.")
-		icecastTimeout   = flag.Duration("icecast.timeout", 5*time.Second, "Timeout for trying to get stats from Icecast.")
+		configFile              = flag.String("config.file", "", "YAML file listing several Icecast servers to scrape instead of -icecast.scrape-uri.")
+		listenAddress           = flag.String("web.listen-address", ":9146", "Address to listen on for web interface and telemetry.")
+		healthListenAddress     = flag.String("web.health-listen-address", "", "Address to serve /-/healthy and /-/ready on instead of -web.listen-address, e.g. for a load balancer.")
+		metricsPath             = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+		timeoutOffset           = flag.Duration("web.timeout-offset", 500*time.Millisecond, "Subtracted from the scrape timeout Prometheus sends, so the Icecast scrape is aborted before Prometheus gives up.")
+		enablePprof             = flag.Bool("web.enable-pprof", false, "Serve Go profiling data under /debug/pprof/.")
+		disableExporterMetrics  = flag.Bool("web.disable-exporter-metrics", false, "Exclude the icecast_exporter_* metrics about the exporter itself.")
+		landingPageFile         = flag.String("web.landing-page", "", "HTML template to serve as landing page instead of the built-in one, with {{.MetricsPath}} and {{.Version}} available.")
+		icecastScrapeURI        = flag.String("icecast.scrape-uri", "http://localhost:8000/status-json.xsl", "URI on which to scrape Icecast, or a file:// URI of a status document to read instead.")
+		icecastTimeout          = flag.Duration("icecast.timeout", 5*time.Second, "Timeout for trying to get stats from Icecast.")
+		icecastConnectTimeout   = flag.Duration("icecast.connect-timeout", 0, "Timeout for connecting to Icecast. Defaults to -icecast.timeout.")
+		icecastTLSTimeout       = flag.Duration("icecast.tls-handshake-timeout", 0, "Timeout for the TLS handshake with Icecast after connecting. Defaults to -icecast.connect-timeout.")
+		icecastIPProtocol       = flag.String("icecast.ip-protocol", "", "IP version to connect to Icecast with: \"ip4\" or \"ip6\". Either by default.")
+		icecastIPFallback       = flag.Bool("icecast.ip-protocol-fallback", true, "Try the other IP version if connecting with -icecast.ip-protocol fails.")
+		icecastSourceAddress    = flag.String("icecast.source-address", "", "Local IP address to connect to Icecast from, e.g. for ACLs that only trust a management address.")
+		icecastMaxRedirects     = flag.Int("icecast.max-redirects", 10, "Number of redirects to follow when scraping Icecast. 0 disables following redirects.")
+		icecastRequestTimeout   = flag.Duration("icecast.request-timeout", 0, "Timeout for the whole request to Icecast, including connecting. Defaults to -icecast.timeout, 0 disables it.")
+		icecastCacheTTL         = flag.Duration("icecast.cache-ttl", 0, "Reuse the last Icecast status for this long instead of scraping on every request, with ±10% random jitter. 0 disables caching.")
+		icecastFailOnStartup    = flag.Bool("icecast.fail-on-startup", false, "Scrape Icecast once on startup and exit if that fails.")
+		icecastBreakerThreshold = flag.Int("icecast.breaker-threshold", 0, "Number of consecutive failed scrapes after which Icecast isn't scraped for -icecast.breaker-cooldown. 0 disables the circuit breaker.")
+		icecastRetries          = flag.Int("icecast.retries", 0, "How often to repeat a request to Icecast that failed with a connection error or 5xx status within a scrape.")
+		icecastRetryBackoff     = flag.Duration("icecast.retry-backoff", 100*time.Millisecond, "How long to wait before the first retry, doubled for each further one.")
+		icecastBreakerCooldown  = flag.Duration("icecast.breaker-cooldown", 30*time.Second, "How long to stop scraping Icecast after -icecast.breaker-threshold consecutive failures.")
+		icecastUnixSocket       = flag.String("icecast.unix-socket", "", "Unix domain socket to connect to instead of the host of -icecast.scrape-uri, e.g. /var/run/icecast/http.sock.")
+		icecastProxyURL         = flag.String("icecast.proxy-url", "", "HTTP, HTTPS or SOCKS5 proxy to scrape Icecast through, e.g. socks5://bastion:1080. Defaults to the proxy environment variables.")
+		icecastExpectedMounts   = flag.String("icecast.expected-mounts", "", "Comma separated mount points to export icecast_source_up for, which is 0 while they're missing from the status.")
+		icecastMountLabels      = flag.String("icecast.mount-labels", "", "Static labels to add to the metrics of mount points, as \"/mount=name:value,...\".")
+		icecastCAFile           = flag.String("icecast.ca-file", "", "CA certificates file to verify Icecast's certificate with instead of the system's.")
+		icecastTLSSkipVerify    = flag.Bool("icecast.tls-skip-verify", false, "Don't verify Icecast's certificate. Insecure, only meant for lab setups with self-signed certificates.")
+		icecastCertFile         = flag.String("icecast.cert-file", "", "Client certificate file for scraping Icecast over HTTPS.")
+		icecastKeyFile          = flag.String("icecast.key-file", "", "Client certificate key file for scraping Icecast over HTTPS.")
+		icecastBearerToken      = flag.String("icecast.bearer-token", "", "Bearer token to send when scraping Icecast. Defaults to the ICECAST_EXPORTER_BEARER_TOKEN environment variable.")
+		icecastBearerTokenFile  = flag.String("icecast.bearer-token-file", "", "File containing the bearer token to send when scraping Icecast.")
+		icecastUsername         = flag.String("icecast.username", "", "Username for scraping Icecast, e.g. admin for /admin/stats.")
+		icecastPassword         = flag.String("icecast.password", "", "Password for -icecast.username.")
+		icecastPasswordFile     = flag.String("icecast.password-file", "", "File containing the password for -icecast.username.")
+		icecastCredentialCmd    = flag.String("icecast.credential-command", "", "Command run through sh whose output is \"username:password\" or a bearer token for scraping Icecast. The output is cached for a minute.")
+		icecastAuth             = flag.String("icecast.auth", "basic", "How to send -icecast.username and -icecast.password: \"basic\" or \"digest\".")
+		icecastAlsoScrapeAdmin  = flag.Bool("icecast.also-scrape-admin", false, "Also scrape /admin/stats for connection and byte counters and prefer its per-mount values. Requires admin credentials, e.g. via -icecast.username.")
+		icecastExpectedBitrate  = flag.Float64("icecast.expected-bitrate", 0, "Bitrate in kbit/s below which sources are counted in icecast_sources_below_expected_bitrate. 0 disables it.")
+		icecastSmoothingWindow  = flag.Int("icecast.smoothing-window", 0, "Number of scrapes to average icecast_listeners_moving_average over. 0 disables it.")
+		icecastTimestampOnError = flag.String("icecast.timestamp-on-error", "nan", "Value of timestamps Icecast doesn't report or that can't be parsed: \"nan\", \"zero\" or \"skip\" to omit the series.")
+		icecastListClients      = flag.Bool("icecast.list-clients", false, "Export icecast_listener_connected_seconds, icecast_unique_listeners and icecast_listeners_by_player from /admin/listclients. Requires admin credentials, e.g. via -icecast.username.")
+		icecastListMounts       = flag.Bool("icecast.list-mounts", false, "Export icecast_mount_listeners and icecast_mount_connected_seconds from /admin/listmounts, which includes hidden mount points. Requires admin credentials, e.g. via -icecast.username.")
+		icecastGeoIPDB          = flag.String("icecast.geoip-db", "", "MaxMind GeoIP2/GeoLite2 country database to export icecast_listeners_by_country from /admin/listclients. Requires admin credentials, e.g. via -icecast.username.")
 	)
+	icecastUserAgent := flag.String("icecast.user-agent", defaultUserAgent(version.Version), "User-Agent to send when scraping Icecast.")
+	icecastHeaders := headerFlag{}
+	flag.Var(icecastHeaders, "icecast.header", "Header to send when scraping Icecast, as \"Name: Value\". May be repeated.")
+
+	// "icecast_exporter check [flags]" scrapes Icecast once and exits 0 or
+	// 1, e.g. for a Docker HEALTHCHECK.
+	check := len(os.Args) > 1 && os.Args[1] == "check"
+	if check {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	flag.Parse()
 
 	// Listen to signals
 	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(sigchan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 
-	exporter := NewExporter(*icecastScrapeURI, *icecastTimeout)
-	prometheus.MustRegister(exporter)
+	scrapeURI, err := normalizeScrapeURI(*icecastScrapeURI)
+	if err != nil {
+		log.Fatalf("Invalid scrape URI %q: %v", *icecastScrapeURI, err)
+	}
 
-	// Setup HTTP server
-	http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-             <head><title>Icecast Exporter</title></head>
-             <body>
-             <h1>Icecast Exporter</h1>
-             <p><a href='` + *metricsPath + `'>Metrics</a></p>
-             </body>
-             </html>`))
+	switch *icecastAuth {
+	case "basic", "digest":
+	default:
+		log.Fatalf("Invalid -icecast.auth %q, must be basic or digest", *icecastAuth)
+	}
+
+	switch *icecastIPProtocol {
+	case "", "ip4", "ip6":
+	default:
+		log.Fatalf("Invalid -icecast.ip-protocol %q, must be ip4 or ip6", *icecastIPProtocol)
+	}
+
+	var sourceAddress net.IP
+	if *icecastSourceAddress != "" {
+		if sourceAddress = net.ParseIP(*icecastSourceAddress); sourceAddress == nil {
+			log.Fatalf("Invalid -icecast.source-address %q, must be an IP address", *icecastSourceAddress)
+		}
+	}
+
+	switch *icecastTimestampOnError {
+	case "nan", "zero", "skip":
+	default:
+		log.Fatalf("Invalid -icecast.timestamp-on-error %q, must be nan, zero or skip", *icecastTimestampOnError)
+	}
+
+	var proxyURL *url.URL
+	if *icecastProxyURL != "" {
+		if proxyURL, err = parseProxyURL(*icecastProxyURL); err != nil {
+			log.Fatalf("Invalid proxy URL: %v", err)
+		}
+	}
+	if *icecastUnixSocket != "" && proxyURL != nil {
+		log.Fatalf("-icecast.unix-socket and -icecast.proxy-url are mutually exclusive")
+	}
+	if *icecastUnixSocket != "" && sourceAddress != nil {
+		log.Fatalf("-icecast.unix-socket and -icecast.source-address are mutually exclusive")
+	}
+
+	var expectedMounts []string
+	if *icecastExpectedMounts != "" {
+		expectedMounts = strings.Split(*icecastExpectedMounts, ",")
+	}
+
+	mountLabels, err := parseMountLabels(*icecastMountLabels)
+	if err != nil {
+		log.Fatalf("Invalid mount labels: %v", err)
+	}
+
+	var geoipDB *geoip2.Reader
+	if *icecastGeoIPDB != "" {
+		if geoipDB, err = geoip2.Open(*icecastGeoIPDB); err != nil {
+			log.Fatalf("Can't open GeoIP database: %v", err)
+		}
+	}
+
+	landingPage := template.Must(template.New("landing").Parse(defaultLandingPage))
+	if *landingPageFile != "" {
+		if landingPage, err = template.ParseFiles(*landingPageFile); err != nil {
+			log.Fatalf("Can't parse landing page: %v", err)
+		}
+	}
+
+	if *icecastConnectTimeout == 0 {
+		*icecastConnectTimeout = *icecastTimeout
+	}
+	if *icecastTLSTimeout == 0 {
+		*icecastTLSTimeout = *icecastConnectTimeout
+	}
+	// Unlike for the connect timeout, an explicit 0 disables the request
+	// timeout.
+	requestTimeoutSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "icecast.request-timeout" {
+			requestTimeoutSet = true
+		}
 	})
+	if !requestTimeoutSet {
+		*icecastRequestTimeout = *icecastTimeout
+	}
 
-	go func() {
-		log.Infof("Starting Server: %s", *listenAddress)
-		log.Fatal(http.ListenAndServe(*listenAddress, nil))
-	}()
+	// loadOptions reads credential files, so it's called again on SIGHUP.
+	loadOptions := func() (Options, error) {
+		bearerToken := *icecastBearerToken
+		// Keeps the token out of the process list.
+		if bearerToken == "" && *icecastBearerTokenFile == "" {
+			bearerToken = os.Getenv("ICECAST_EXPORTER_BEARER_TOKEN")
+		}
+		if *icecastBearerTokenFile != "" {
+			if bearerToken != "" {
+				return Options{}, fmt.Errorf("-icecast.bearer-token and -icecast.bearer-token-file are mutually exclusive")
+			}
+			var err error
+			if bearerToken, err = readSecretFile(*icecastBearerTokenFile); err != nil {
+				return Options{}, fmt.Errorf("can't read bearer token: %v", err)
+			}
+		}
+		password := *icecastPassword
+		if *icecastPasswordFile != "" {
+			if password != "" {
+				return Options{}, fmt.Errorf("-icecast.password and -icecast.password-file are mutually exclusive")
+			}
+			var err error
+			if password, err = readSecretFile(*icecastPasswordFile); err != nil {
+				return Options{}, fmt.Errorf("can't read password: %v", err)
+			}
+		}
+		if bearerToken != "" && *icecastUsername != "" {
+			return Options{}, fmt.Errorf("a bearer token and -icecast.username are mutually exclusive")
+		}
+		if *icecastCredentialCmd != "" && (bearerToken != "" || *icecastUsername != "") {
+			return Options{}, fmt.Errorf("-icecast.credential-command is mutually exclusive with other credentials")
+		}
+
+		tlsConfig := &tls.Config{InsecureSkipVerify: *icecastTLSSkipVerify}
+		if (*icecastCertFile == "") != (*icecastKeyFile == "") {
+			return Options{}, fmt.Errorf("-icecast.cert-file and -icecast.key-file must be given together")
+		}
+		if *icecastCertFile != "" {
+			cert, err := tls.LoadX509KeyPair(*icecastCertFile, *icecastKeyFile)
+			if err != nil {
+				return Options{}, fmt.Errorf("can't load client certificate: %v", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		if *icecastCAFile != "" {
+			pem, err := ioutil.ReadFile(*icecastCAFile)
+			if err != nil {
+				return Options{}, fmt.Errorf("can't read CA file: %v", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return Options{}, fmt.Errorf("no certificates found in CA file %s", *icecastCAFile)
+			}
+		}
+
+		return Options{
+			TLSConfig:        tlsConfig,
+			ProxyURL:         proxyURL,
+			UnixSocket:       *icecastUnixSocket,
+			ConnectTimeout:   *icecastConnectTimeout,
+			RequestTimeout:   *icecastRequestTimeout,
+			BearerToken:      bearerToken,
+			Username:         *icecastUsername,
+			Password:         password,
+			Auth:             *icecastAuth,
+			Headers:          http.Header(icecastHeaders),
+			UserAgent:        *icecastUserAgent,
+			CacheTTL:         *icecastCacheTTL,
+			BreakerThreshold: *icecastBreakerThreshold,
+			BreakerCooldown:  *icecastBreakerCooldown,
+			Retries:          *icecastRetries,
+			RetryBackoff:     *icecastRetryBackoff,
+			ExpectedBitrate:  *icecastExpectedBitrate,
+			SmoothingWindow:  *icecastSmoothingWindow,
+			TimestampOnError: *icecastTimestampOnError,
+			AlsoScrapeAdmin:  *icecastAlsoScrapeAdmin,
+			ListClients:      *icecastListClients,
+			ListMounts:       *icecastListMounts,
+			GeoIP:            geoipDB,
+			ExpectedMounts:   expectedMounts,
+			MountLabels:      mountLabels,
+
+			CredentialCommand:      *icecastCredentialCmd,
+			TLSHandshakeTimeout:    *icecastTLSTimeout,
+			IPProtocol:             *icecastIPProtocol,
+			IPProtocolFallback:     *icecastIPFallback,
+			SourceAddress:          sourceAddress,
+			MaxRedirects:           *icecastMaxRedirects,
+			DisableExporterMetrics: *disableExporterMetrics,
+		}, nil
+	}
+
+	opts, err := loadOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Without -config.file, the single server has no name and its metrics no
+	// server label.
+	icecastServers := []ServerConfig{{ScrapeURI: scrapeURI}}
+	if *configFile != "" {
+		config, err := loadConfig(*configFile)
+		if err != nil {
+			log.Fatalf("Can't load config file: %v", err)
+		}
+		icecastServers = config.Servers
+	}
+	exporters := make([]*Exporter, 0, len(icecastServers))
+	namedExporters := make(map[string]*Exporter, len(icecastServers))
+	for _, server := range icecastServers {
+		exporter := NewExporter(server.ScrapeURI, server.options(opts))
+		exporters = append(exporters, exporter)
+		namedExporters[server.Name] = exporter
+	}
+	relays := map[string]relayCollector{}
+	for _, server := range icecastServers {
+		if server.RelayOf != "" {
+			relays[server.Name] = relayCollector{
+				masterName: server.RelayOf,
+				master:     namedExporters[server.RelayOf],
+				relay:      namedExporters[server.Name],
+			}
+		}
+	}
+	// Options for /probe, swapped on SIGHUP like the exporter's.
+	var probeOpts atomic.Value
+	probeOpts.Store(opts)
+
+	if check {
+		failed := false
+		for _, exporter := range exporters {
+			if err := exporter.Check(context.Background()); err != nil {
+				log.Errorf("Can't scrape Icecast %s: %v", redactURI(exporter.URI), err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *icecastFailOnStartup {
+		for _, exporter := range exporters {
+			if err := exporter.Check(context.Background()); err != nil {
+				log.Fatalf("Can't scrape Icecast %s on startup: %v", redactURI(exporter.URI), err)
+			}
+		}
+	}
 
-	s := <-sigchan
-	log.Infof("Received %v, terminating", s)
-	os.Exit(0)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	if !*disableExporterMetrics {
+		startTime := prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_start_time_seconds",
+			Help:      "Start time of the exporter since unix epoch in seconds.",
+		})
+		startTime.Set(float64(time.Now().Unix()))
+		registry.MustRegister(version.NewCollector("icecast_exporter"), startTime)
+	}
+
+	// Setup HTTP server. A mux of our own keeps net/http/pprof off unless
+	// enabled, as importing it registers on http.DefaultServeMux.
+	mux := http.NewServeMux()
+	mux.Handle(*metricsPath, promhttp.InstrumentMetricHandler(
+		registry, metricsHandler(namedExporters, relays, registry, *timeoutOffset),
+	))
+	mux.Handle("/targets", targetsHandler(exporters...))
+	mux.Handle("/probe", probeHandler(func() Options { return probeOpts.Load().(Options) }, *timeoutOffset))
+	if *enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		data := struct{ MetricsPath, Version string }{*metricsPath, version.Version}
+		if err := landingPage.Execute(w, data); err != nil {
+			log.Errorf("Can't render landing page: %v", err)
+		}
+	})
+
+	servers := []*http.Server{{Addr: *listenAddress, Handler: mux}}
+	healthMux := mux
+	if *healthListenAddress != "" {
+		healthMux = http.NewServeMux()
+		servers = append(servers, &http.Server{Addr: *healthListenAddress, Handler: healthMux})
+	}
+	healthMux.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Healthy")
+	})
+	healthMux.HandleFunc("/-/ready", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Ready")
+	})
+
+	for _, server := range servers {
+		go func(server *http.Server) {
+			log.Infof("Starting Server: %s", server.Addr)
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}(server)
+	}
+
+	for s := range sigchan {
+		if s == syscall.SIGHUP {
+			opts, err := loadOptions()
+			if err != nil {
+				log.Errorf("Can't reload: %v", err)
+				continue
+			}
+			for i, exporter := range exporters {
+				exporter.Reload(icecastServers[i].options(opts))
+			}
+			probeOpts.Store(opts)
+			log.Infof("Received %v, reloaded credentials", s)
+			continue
+		}
+		log.Infof("Received %v, terminating", s)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		for _, server := range servers {
+			if err := server.Shutdown(ctx); err != nil {
+				log.Errorf("Can't shut down server %s: %v", server.Addr, err)
+			}
+		}
+		cancel()
+		os.Exit(0)
+	}
 }
@@ -0,0 +1,750 @@
+// Copyright 2016 Markus Lindenberg
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newFileExporter returns an exporter reading the status document status
+// from a temporary file.
+func newFileExporter(t *testing.T, status string, opts Options) *Exporter {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "status-json.xsl")
+	if err := ioutil.WriteFile(filename, []byte(status), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return NewExporter("file://"+filename, opts)
+}
+
+// collect runs one collect of e and returns the metrics.
+func collect(e *Exporter) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		e.Collect(ch)
+		close(ch)
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func TestMissingStreamStartIsNaN(t *testing.T) {
+	e := newFileExporter(t, `{"icestats":{"source":{"listenurl":"http://localhost:8000/live","server_type":"audio/mpeg"}}}`, Options{})
+	collect(e)
+
+	if v := testutil.ToFloat64(e.streamStart.WithLabelValues("http://localhost:8000/live", "audio/mpeg")); !math.IsNaN(v) {
+		t.Errorf("stream_start = %v, want NaN", v)
+	}
+	if v := testutil.ToFloat64(e.serverStart); !math.IsNaN(v) {
+		t.Errorf("server_start = %v, want NaN", v)
+	}
+}
+
+func FuzzParseStatus(f *testing.F) {
+	f.Add([]byte(`{"icestats":{"host":"localhost","source":[{"listenurl":"http://localhost:8000/a","listeners":1},{"listenurl":"http://localhost:8000/b","listeners":2}]}}`))
+	f.Add([]byte(`{"icestats":{"host":"localhost","source":{"listenurl":"http://localhost:8000/a","listeners":1}}}`))
+	f.Add([]byte(`{"icestats":{"host":"localhost","source":null}}`))
+	f.Add([]byte(`{"icestats":{"host":"localhost"}}`))
+	f.Add([]byte(`not json`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s, err := parseStatus(bytes.NewReader(data))
+		if err == nil && s == nil {
+			t.Error("parseStatus returned neither status nor error")
+		}
+	})
+}
+
+func TestJSONParseFailures(t *testing.T) {
+	e := newFileExporter(t, `{"icestats":`, Options{})
+	collect(e)
+	if v := testutil.ToFloat64(e.jsonParseFailures); v != 1 {
+		t.Errorf("json_parse_failures = %v after a malformed status, want 1", v)
+	}
+
+	e = newFileExporter(t, `{"icestats":{"source":[]}}`, Options{})
+	collect(e)
+	if v := testutil.ToFloat64(e.jsonParseFailures); v != 0 {
+		t.Errorf("json_parse_failures = %v after a valid status, want 0", v)
+	}
+}
+
+func TestErrorReason(t *testing.T) {
+	// A port that was just free, so connecting to it is refused.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := "http://" + l.Addr().String() + "/status-json.xsl"
+	l.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/forbidden", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	mux.HandleFunc("/unavailable", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, test := range []struct {
+		uri, reason string
+	}{
+		{closedPort, "connect"},
+		{"http://icecast.invalid/status-json.xsl", "dns"},
+		{srv.URL + "/forbidden", "http_4xx"},
+		{srv.URL + "/unavailable", "http_5xx"},
+	} {
+		e := NewExporter(test.uri, Options{ConnectTimeout: 5 * time.Second, RequestTimeout: 5 * time.Second})
+		_, _, err := e.fetch(context.Background())
+		if err == nil {
+			t.Errorf("%s: no error", test.uri)
+			continue
+		}
+		if reason := errorReason(err); reason != test.reason {
+			t.Errorf("%s: reason %q for %v, want %q", test.uri, reason, err, test.reason)
+		}
+	}
+}
+
+// statusServer serves a fixed status document, or 503 while failing is set.
+type statusServer struct {
+	*httptest.Server
+	failing  int32
+	requests int32
+}
+
+func newStatusServer(status string) *statusServer {
+	s := &statusServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.requests, 1)
+		if atomic.LoadInt32(&s.failing) != 0 {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(status))
+	}))
+	return s
+}
+
+func (s *statusServer) fail(fail bool) {
+	var v int32
+	if fail {
+		v = 1
+	}
+	atomic.StoreInt32(&s.failing, v)
+}
+
+var testOptions = Options{ConnectTimeout: 5 * time.Second, RequestTimeout: 5 * time.Second}
+
+func TestReloadKeepsCounters(t *testing.T) {
+	srv := newStatusServer(`{"icestats":{"source":[]}}`)
+	defer srv.Close()
+
+	e := NewExporter(srv.URL, testOptions)
+	collect(e)
+	collect(e)
+	client := e.client
+	e.Reload(testOptions)
+	if e.client == client {
+		t.Error("Reload kept the HTTP client")
+	}
+	if v := testutil.ToFloat64(e.totalScrapes); v != 2 {
+		t.Errorf("total_scrapes = %v after reload, want 2", v)
+	}
+	collect(e)
+	if v := testutil.ToFloat64(e.totalScrapes); v != 3 {
+		t.Errorf("total_scrapes = %v after another scrape, want 3", v)
+	}
+}
+
+func TestTargetsHandler(t *testing.T) {
+	srv := newStatusServer(`{"icestats":{"source":[]}}`)
+	defer srv.Close()
+
+	up := NewExporter(srv.URL, testOptions)
+	down := NewExporter(srv.URL+"/missing", testOptions)
+	malformed := newFileExporter(t, `{"icestats":`, testOptions)
+	collect(up)
+	collect(malformed)
+	srv.fail(true)
+	collect(down)
+
+	rec := httptest.NewRecorder()
+	targetsHandler(up, down, malformed).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/targets", nil))
+	var targets []TargetStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &targets); err != nil {
+		t.Fatalf("can't decode %q: %v", rec.Body.String(), err)
+	}
+	if len(targets) != 3 {
+		t.Fatalf("got %d targets, want 3", len(targets))
+	}
+	if targets[0].URI != srv.URL || !targets[0].Up || targets[0].LastScrape.IsZero() || targets[0].LastError != "" {
+		t.Errorf("unexpected status of a successful target: %+v", targets[0])
+	}
+	if targets[1].Up || targets[1].LastScrape.IsZero() || targets[1].LastError == "" {
+		t.Errorf("unexpected status of a failed target: %+v", targets[1])
+	}
+	// Icecast answered, so the target is up as in icecast_up, but the
+	// status couldn't be parsed.
+	if v := testutil.ToFloat64(malformed.up); v != 1 {
+		t.Errorf("up = %v for a malformed status, want 1", v)
+	}
+	if !targets[2].Up || targets[2].LastError == "" {
+		t.Errorf("unexpected status of a target with a malformed status: %+v", targets[2])
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	srv := newStatusServer(`{"icestats":{"source":[]}}`)
+	defer srv.Close()
+
+	opts := testOptions
+	opts.BreakerThreshold = 2
+	opts.BreakerCooldown = time.Minute
+	e := NewExporter(srv.URL, opts)
+	expect := func(state string, wantRequests int32, wantUp float64) {
+		t.Helper()
+		collect(e)
+		if n := atomic.LoadInt32(&srv.requests); n != wantRequests {
+			t.Errorf("%s: %d requests, want %d", state, n, wantRequests)
+		}
+		if v := testutil.ToFloat64(e.up); v != wantUp {
+			t.Errorf("%s: up = %v, want %v", state, v, wantUp)
+		}
+	}
+
+	// Closed: every collect scrapes, until the threshold is reached.
+	srv.fail(true)
+	expect("closed", 1, 0)
+	expect("closed", 2, 0)
+	// Open: collects don't scrape during the cooldown.
+	expect("open", 2, 0)
+	expect("open", 2, 0)
+	// Half-open: after the cooldown, one scrape is attempted. It fails, so
+	// the breaker opens again.
+	e.lastAttempt = time.Now().Add(-opts.BreakerCooldown)
+	expect("half-open", 3, 0)
+	expect("reopened", 3, 0)
+	// Half-open again, this time the scrape succeeds and closes the breaker.
+	e.lastAttempt = time.Now().Add(-opts.BreakerCooldown)
+	srv.fail(false)
+	expect("half-open", 4, 1)
+	expect("closed", 5, 1)
+}
+
+func TestZeroRequestTimeout(t *testing.T) {
+	srv := newStatusServer(`{"icestats":{"source":[]}}`)
+	defer srv.Close()
+
+	e := NewExporter(srv.URL, Options{})
+	collect(e)
+	if v := testutil.ToFloat64(e.up); v != 1 {
+		t.Errorf("up = %v without a request timeout, want 1", v)
+	}
+}
+
+func TestByteOrderMark(t *testing.T) {
+	for _, prefix := range []string{"\xef\xbb\xbf", "\xef\xbb\xbf\r\n", "\n "} {
+		e := newFileExporter(t, prefix+`{"icestats":{"source":{"listenurl":"http://localhost:8000/live","listeners":3}}}`, Options{})
+		collect(e)
+		if v := testutil.ToFloat64(e.jsonParseFailures); v != 0 {
+			t.Errorf("%q: json_parse_failures = %v, want 0", prefix, v)
+		}
+		if s := e.LastStatus(); s == nil || len(s.Icestats.Source) != 1 || s.Icestats.Source[0].Listeners != 3 {
+			t.Errorf("%q: unexpected status %+v", prefix, s)
+		}
+	}
+}
+
+func TestUnparseableNumber(t *testing.T) {
+	var source IcecastStatusSource
+	if err := json.Unmarshal([]byte(`{"bitrate":"128k","audio_bitrate":"96000","samplerate":"44.1 kHz","max_listeners":"lots"}`), &source); err != nil {
+		t.Fatalf("unparseable numbers failed the status: %v", err)
+	}
+	if bitrate, ok := source.bitrate(); !ok || bitrate != 96 {
+		t.Errorf("bitrate = %v, %v, want the audio_bitrate of 96", bitrate, ok)
+	}
+	if samplerate, ok := source.audioField("samplerate", source.AudioSamplerate, source.Samplerate); ok {
+		t.Errorf("samplerate = %v, want it unset", samplerate)
+	}
+	if source.MaxListeners != nil && *source.MaxListeners != 0 {
+		t.Errorf("max_listeners = %v, want unset", *source.MaxListeners)
+	}
+}
+
+func TestSingleSourceFallback(t *testing.T) {
+	for _, test := range []struct {
+		source   string
+		fallback float64
+		failures float64
+	}{
+		{`[{"listenurl":"http://localhost:8000/a"},{"listenurl":"http://localhost:8000/b"}]`, 0, 0},
+		{`{"listenurl":"http://localhost:8000/a"}`, 1, 0},
+		{`null`, 0, 0},
+		// Errors within an object or array aren't retried as the other shape.
+		{`{"listenurl":"http://localhost:8000/a","listeners":"many"}`, 0, 1},
+		{`[{"listenurl":"http://localhost:8000/a","listeners":"many"}]`, 0, 1},
+		{`"http://localhost:8000/a"`, 0, 1},
+	} {
+		e := newFileExporter(t, `{"icestats":{"source":`+test.source+`}}`, Options{})
+		collect(e)
+		if v := testutil.ToFloat64(e.singleSourceFallback); v != test.fallback {
+			t.Errorf("%s: single_source_fallback = %v, want %v", test.source, v, test.fallback)
+		}
+		if v := testutil.ToFloat64(e.jsonParseFailures); v != test.failures {
+			t.Errorf("%s: json_parse_failures = %v, want %v", test.source, v, test.failures)
+		}
+	}
+}
+
+func TestDecodeJSONTrailingData(t *testing.T) {
+	for _, data := range []string{
+		`{"icestats":{"host":"a"}}`,
+		"{\"icestats\":{\"host\":\"a\"}}\r\n",
+		`{"icestats":{"host":"a"}}{"icestats":{"host":"b"}}`,
+		`{"icestats":{"host":"a"}}<html>proxy error</html>`,
+		"{\"icestats\":{\"host\":\"a\"}}\x00\x00",
+	} {
+		var s IcecastStatus
+		if err := decodeJSON(strings.NewReader(data), &s); err != nil {
+			t.Errorf("%q: %v", data, err)
+			continue
+		}
+		if s.Icestats.Host != "a" {
+			t.Errorf("%q: host = %q, want the first document's", data, s.Icestats.Host)
+		}
+	}
+
+	var s IcecastStatus
+	if err := decodeJSON(strings.NewReader(`{"icestats":{"host":`), &s); err == nil {
+		t.Error("truncated document decoded without error")
+	}
+}
+
+func TestTimestampOnError(t *testing.T) {
+	const status = `{"icestats":{"server_start_iso8601":"sometime","source":{"listenurl":"http://localhost:8000/live","server_type":"audio/mpeg","stream_start_iso8601":"2021-09-14T20:05:21+0200"}}}`
+	for _, test := range []struct {
+		mode   string
+		want   float64
+		series int
+	}{
+		{"", math.NaN(), 1},
+		{"nan", math.NaN(), 1},
+		{"zero", 0, 1},
+		{"skip", 0, 0},
+	} {
+		e := newFileExporter(t, status, Options{TimestampOnError: test.mode})
+		var n int
+		for _, m := range collect(e) {
+			if m.Desc() == e.serverStart.Desc() {
+				n++
+			}
+		}
+		if n != test.series {
+			t.Errorf("%q: got %d server_start series, want %d", test.mode, n, test.series)
+		}
+		if test.series == 0 {
+			continue
+		}
+		v := testutil.ToFloat64(e.serverStart)
+		if v != test.want && !(math.IsNaN(v) && math.IsNaN(test.want)) {
+			t.Errorf("%q: server_start = %v, want %v", test.mode, v, test.want)
+		}
+		// Valid timestamps are exported regardless of the mode.
+		if v := testutil.ToFloat64(e.streamStart.WithLabelValues("http://localhost:8000/live", "audio/mpeg")); v != 1631642721 {
+			t.Errorf("%q: stream_start = %v, want 1631642721", test.mode, v)
+		}
+	}
+}
+
+func TestCancelledScrapeDoesntLeakGoroutines(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	before := runtime.NumGoroutine()
+	e := NewExporter(srv.URL, testOptions)
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		ch := make(chan prometheus.Metric)
+		go func() {
+			for range ch {
+			}
+		}()
+		e.collect(ctx, ch)
+		close(ch)
+		cancel()
+	}
+	e.client.CloseIdleConnections()
+
+	// Give the scrape and connection goroutines a moment to exit.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines after cancelled scrapes, want at most %d", n, before)
+	}
+}
+
+// sources returns a status with a source per listenurl and listeners.
+func sources(listeners map[string]int) *IcecastStatus {
+	var s IcecastStatus
+	for listenurl, n := range listeners {
+		s.Icestats.Source = append(s.Icestats.Source, IcecastStatusSource{Listenurl: listenurl, Listeners: n})
+	}
+	return &s
+}
+
+func TestRecordListeners(t *testing.T) {
+	e := NewExporter("http://localhost:8000/status-json.xsl", Options{SmoothingWindow: 3})
+	for _, n := range []int{1, 2, 3, 6} {
+		e.recordListeners(sources(map[string]int{"http://localhost:8000/a": n, "http://localhost:8000/b": 10 * n}))
+	}
+	want := map[string][]int{
+		"http://localhost:8000/a": {2, 3, 6},
+		"http://localhost:8000/b": {20, 30, 60},
+	}
+	if !reflect.DeepEqual(e.listenerHistory, want) {
+		t.Errorf("got history %v, want %v", e.listenerHistory, want)
+	}
+
+	// /a goes away, and starts over when it's back.
+	e.recordListeners(sources(map[string]int{"http://localhost:8000/b": 70}))
+	e.recordListeners(sources(map[string]int{"http://localhost:8000/a": 9, "http://localhost:8000/b": 80}))
+	want = map[string][]int{
+		"http://localhost:8000/a": {9},
+		"http://localhost:8000/b": {60, 70, 80},
+	}
+	if !reflect.DeepEqual(e.listenerHistory, want) {
+		t.Errorf("got history %v, want %v", e.listenerHistory, want)
+	}
+}
+
+func TestListenersMovingAverage(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "status-json.xsl")
+	e := NewExporter("file://"+filename, Options{SmoothingWindow: 3})
+	for _, test := range []struct {
+		listeners int
+		average   float64
+	}{
+		{1, 1},
+		{2, 1.5},
+		{6, 3},
+		{10, 6},
+	} {
+		status := fmt.Sprintf(`{"icestats":{"source":{"listenurl":"http://localhost:8000/a","server_type":"audio/mpeg","listeners":%d}}}`, test.listeners)
+		if err := ioutil.WriteFile(filename, []byte(status), 0644); err != nil {
+			t.Fatal(err)
+		}
+		collect(e)
+		if v := testutil.ToFloat64(e.listenersMovingAverage.WithLabelValues("http://localhost:8000/a", "audio/mpeg")); v != test.average {
+			t.Errorf("after %d listeners: listeners_moving_average = %v, want %v", test.listeners, v, test.average)
+		}
+	}
+}
+
+func TestProbeSendsNoCredentials(t *testing.T) {
+	var mu sync.Mutex
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r)
+		mu.Unlock()
+		w.Write([]byte(`{"icestats":{"source":[]}}`))
+	}))
+	defer srv.Close()
+
+	opts := testOptions
+	opts.BearerToken = "s3cr3t"
+	opts.Username = "admin"
+	opts.Password = "hackme"
+	opts.CredentialCommand = "echo admin:hackme"
+	opts.Headers = http.Header{"X-Api-Key": {"s3cr3t"}}
+	opts.AlsoScrapeAdmin = true
+	opts.ListClients = true
+	opts.ListMounts = true
+	rec := httptest.NewRecorder()
+	probeHandler(func() Options { return opts }, 0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?target="+srv.URL, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 1 {
+		t.Errorf("got %d requests, want only the status", len(requests))
+	}
+	for _, r := range requests {
+		if r.URL.Path != "/status-json.xsl" {
+			t.Errorf("probe requested %s", r.URL)
+		}
+		if r.Header.Get("Authorization") != "" || r.Header.Get("X-Api-Key") != "" {
+			t.Errorf("probe sent credentials: %v", r.Header)
+		}
+	}
+}
+
+func TestParseMountLabelsReserved(t *testing.T) {
+	for _, name := range []string{"listenurl", "server_type", "server", "target", "country", "quantile", "player"} {
+		if _, err := parseMountLabels("/live=" + name + ":x"); err == nil {
+			t.Errorf("reserved label name %q was accepted", name)
+		}
+	}
+	labels, err := parseMountLabels("/jazz=channel:JazzFM,/jazz=tier:free")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (prometheus.Labels{"channel": "JazzFM", "tier": "free"}); !reflect.DeepEqual(labels["/jazz"], want) {
+		t.Errorf("got %v, want %v", labels["/jazz"], want)
+	}
+}
+
+func TestScrapeDeadline(t *testing.T) {
+	const mounts = 5
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/admin/listclients" {
+			// Hang until the client gives up.
+			<-r.Context().Done()
+			return
+		}
+		var sources []string
+		for i := 0; i < mounts; i++ {
+			sources = append(sources, fmt.Sprintf(`{"listenurl":"http://localhost:8000/%d"}`, i))
+		}
+		fmt.Fprintf(w, `{"icestats":{"source":[%s]}}`, strings.Join(sources, ","))
+	}))
+	defer srv.Close()
+
+	opts := testOptions
+	opts.RequestTimeout = 200 * time.Millisecond
+	opts.ListClients = true
+	e := NewExporter(srv.URL, opts)
+	start := time.Now()
+	collect(e)
+	if d := time.Since(start); d > mounts*opts.RequestTimeout/2 {
+		t.Errorf("scrape took %v, want it limited to about %v", d, opts.RequestTimeout)
+	}
+	if v := testutil.ToFloat64(e.up); v != 1 {
+		t.Errorf("up = %v, want 1 as the status was scraped", v)
+	}
+}
+
+func TestFailedListMountsKeepsTargetUp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/admin/listmounts" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"icestats":{"source":[]}}`))
+	}))
+	defer srv.Close()
+
+	opts := testOptions
+	opts.ListMounts = true
+	e := NewExporter(srv.URL, opts)
+	collect(e)
+	if target := e.Target(); !target.Up || target.LastError != "" {
+		t.Errorf("got target status %+v after only /admin/listmounts failed, want up", target)
+	}
+}
+
+func TestDefaultUserAgent(t *testing.T) {
+	if ua := defaultUserAgent("0.4.0"); ua != "icecast_exporter/0.4.0" {
+		t.Errorf("got %q for version 0.4.0", ua)
+	}
+	if ua := defaultUserAgent(""); ua != "icecast_exporter" {
+		t.Errorf("got %q without a version, want no trailing slash", ua)
+	}
+}
+
+func TestProbeClosesConnections(t *testing.T) {
+	var closed int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"icestats":{"source":[]}}`))
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			atomic.AddInt32(&closed, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	rec := httptest.NewRecorder()
+	probeHandler(func() Options { return testOptions }, 0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/probe?target="+srv.URL, nil))
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&closed) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&closed) == 0 {
+		t.Error("the connection to the target is still open after the probe")
+	}
+}
+
+func TestDecodeErrorsArentRetried(t *testing.T) {
+	const body = `{"icestats":{"source":[{"listenurl":"http://localhost:8000/live"`
+	srv := newStatusServer(body)
+	defer srv.Close()
+
+	opts := testOptions
+	opts.Retries = 2
+	e := NewExporter(srv.URL, opts)
+	collect(e)
+	if n := atomic.LoadInt32(&srv.requests); n != 1 {
+		t.Errorf("truncated status was requested %d times, want 1", n)
+	}
+	if v := testutil.ToFloat64(e.jsonParseFailures); v != 1 {
+		t.Errorf("json_parse_failures = %v, want 1", v)
+	}
+	if v := testutil.ToFloat64(e.scrapeErrors.WithLabelValues("decode")); v != 1 {
+		t.Errorf("scrape_errors{reason=\"decode\"} = %v, want 1", v)
+	}
+	if v := testutil.ToFloat64(e.scrapeBodyBytes); v != float64(len(body)) {
+		t.Errorf("scrape_body_bytes = %v, want %d", v, len(body))
+	}
+}
+
+func TestScrapeBodyBytes(t *testing.T) {
+	const body = "\xef\xbb\xbf" + `{"icestats":{"source":{"listenurl":"http://localhost:8000/live"}}}` + "\n<!-- proxy -->\n"
+	srv := newStatusServer(body)
+	defer srv.Close()
+
+	e := NewExporter(srv.URL, testOptions)
+	collect(e)
+	if v := testutil.ToFloat64(e.scrapeBodyBytes); v != float64(len(body)) {
+		t.Errorf("scrape_body_bytes = %v, want the whole body of %d bytes", v, len(body))
+	}
+	if v := testutil.ToFloat64(e.singleSourceFallback); v != 1 {
+		t.Errorf("single_source_fallback = %v, want 1", v)
+	}
+	if s := e.LastStatus(); s == nil || len(s.Icestats.Source) != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+// series returns the number of metrics with the descriptor of c.
+func series(metrics []prometheus.Metric, c prometheus.Metric) int {
+	var n int
+	for _, m := range metrics {
+		if m.Desc() == c.Desc() {
+			n++
+		}
+	}
+	return n
+}
+
+// TestServerMetricsAfterFailedScrape checks that the server-wide gauges of a
+// successful scrape aren't exported anymore once a scrape fails.
+func TestServerMetricsAfterFailedScrape(t *testing.T) {
+	srv := newStatusServer(`{"icestats":{"file_connections":3,"listener_peak":9,"server_start_iso8601":"2021-09-14T20:05:21+0200","source":[{"listenurl":"http://localhost:8000/live","server_type":"audio/mpeg","listeners":4,"bitrate":128}]}}`)
+	defer srv.Close()
+
+	opts := testOptions
+	opts.ExpectedBitrate = 192
+	e := NewExporter(srv.URL, opts)
+	gauges := map[string]prometheus.Gauge{
+		"file_connections":               e.fileConnections,
+		"listener_peak":                  e.listenerPeak,
+		"average_bitrate":                e.averageBitrate,
+		"server_types":                   e.serverTypeCount,
+		"sources_future_start":           e.sourcesFutureStart,
+		"sources_below_expected_bitrate": e.sourcesBelowBitrate,
+		"listeners_total":                e.listenersTotal,
+		"active_sources":                 e.activeSources,
+		"server_start":                   e.serverStart,
+		"server_uptime_seconds":          e.serverUptime,
+	}
+
+	metrics := collect(e)
+	for name, g := range gauges {
+		if n := series(metrics, g); n != 1 {
+			t.Errorf("%s: got %d series after a successful scrape, want 1", name, n)
+		}
+	}
+
+	srv.fail(true)
+	metrics = collect(e)
+	for name, g := range gauges {
+		if n := series(metrics, g); n != 0 {
+			t.Errorf("%s: got %d series after a failed scrape, want none", name, n)
+		}
+	}
+}
+
+func TestFlag(t *testing.T) {
+	for data, want := range map[string]Flag{
+		`true`:    true,
+		`false`:   false,
+		`1`:       true,
+		`"1"`:     true,
+		`0`:       false,
+		`"0"`:     false,
+		`null`:    false,
+		`""`:      false,
+		`2`:       true,
+		`-1`:      true,
+		`"yes"`:   false,
+		`"maybe"`: false,
+	} {
+		var source IcecastStatusSource
+		if err := json.Unmarshal([]byte(`{"public":`+data+`}`), &source); err != nil {
+			t.Errorf("%s: %v", data, err)
+			continue
+		}
+		if source.Public != want {
+			t.Errorf("%s: got %v, want %v", data, source.Public, want)
+		}
+	}
+}
+
+func TestNumericAudioInfo(t *testing.T) {
+	var source IcecastStatusSource
+	if err := json.Unmarshal([]byte(`{"audio_info":44100}`), &source); err != nil {
+		t.Fatalf("unquoted audio_info failed the status: %v", err)
+	}
+	if source.AudioInfo != "44100" {
+		t.Errorf("audio_info = %q, want 44100", source.AudioInfo)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 Markus Lindenberg
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"math"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/log"
+)
+
+// IcecastListener is a listener as reported by /admin/listclients.
+type IcecastListener struct {
+	IP        string `xml:"IP"`
+	UserAgent string `xml:"UserAgent"`
+	Connected int    `xml:"Connected"`
+}
+
+// XML structure of /admin/listclients
+type IcecastListClients struct {
+	Source struct {
+		Mount    string            `xml:"mount,attr"`
+		Listener []IcecastListener `xml:"listener"`
+	} `xml:"source"`
+}
+
+// adminURI returns the URI of the admin page path on the scraped server.
+func (e *Exporter) adminURI(path string, query url.Values) string {
+	u, err := url.Parse(e.URI)
+	if err != nil {
+		return e.URI
+	}
+	u.Path = path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// fetchListeners returns the listeners of mount. This requires admin
+// credentials, e.g. -icecast.username and -icecast.password.
+func (e *Exporter) fetchListeners(ctx context.Context, mount string) ([]IcecastListener, error) {
+	body, err := e.get(ctx, e.adminURI("/admin/listclients", url.Values{"mount": {mount}}))
+	if err != nil {
+		return nil, err
+	}
+	var l IcecastListClients
+	if err := xml.Unmarshal(body, &l); err != nil {
+		return nil, err
+	}
+	return l.Source.Listener, nil
+}
+
+// addListeners fetches the listeners of each source in s. Mounts that fail
+// are logged and left without listeners.
+func (e *Exporter) addListeners(ctx context.Context, s *IcecastStatus) {
+	for i := range s.Icestats.Source {
+		source := &s.Icestats.Source[i]
+		mount := mountOf(source.Listenurl)
+		listeners, err := e.fetchListeners(ctx, mount)
+		if err != nil {
+			log.Errorf("Can't list clients of %s: %v", mount, err)
+			continue
+		}
+		// Tell a mount without listeners from one that couldn't be listed.
+		if listeners == nil {
+			listeners = []IcecastListener{}
+		}
+		source.Clients = listeners
+	}
+}
+
+// connectedSummary returns the count, sum and median, 90th and 99th
+// percentile of the seconds listeners have been connected.
+func connectedSummary(listeners []IcecastListener) (uint64, float64, map[float64]float64) {
+	connected := make([]float64, 0, len(listeners))
+	var sum float64
+	for _, l := range listeners {
+		connected = append(connected, float64(l.Connected))
+		sum += float64(l.Connected)
+	}
+	sort.Float64s(connected)
+
+	quantiles := map[float64]float64{}
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		if len(connected) == 0 {
+			quantiles[q] = math.NaN()
+			continue
+		}
+		i := int(math.Ceil(q*float64(len(connected)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		quantiles[q] = connected[i]
+	}
+	return uint64(len(connected)), sum, quantiles
+}
+
+// countUniqueIPs returns the number of distinct IP addresses of listeners, as
+// some players open several connections.
+func countUniqueIPs(listeners []IcecastListener) int {
+	ips := map[string]bool{}
+	for _, l := range listeners {
+		ips[l.IP] = true
+	}
+	return len(ips)
+}
+
+// Substrings of lower cased user agents, checked in this order of the kinds of
+// players. Mobile browsers mention Android or iPhone as well, so apps are
+// recognized by their media frameworks instead.
+var playerUserAgents = []struct {
+	player     string
+	substrings []string
+}{
+	{"bot", []string{"bot", "crawler", "spider", "curl/", "wget/", "python", "go-http-client", "java/"}},
+	{"vlc", []string{"vlc"}},
+	{"mobile_app", []string{"stagefright", "exoplayer", "applecoremedia", "cfnetwork", "dalvik", "okhttp"}},
+	{"browser", []string{"mozilla", "opera"}},
+}
+
+// classifyPlayer returns the kind of player of userAgent, or "other".
+func classifyPlayer(userAgent string) string {
+	userAgent = strings.ToLower(userAgent)
+	for _, p := range playerUserAgents {
+		for _, s := range p.substrings {
+			if strings.Contains(userAgent, s) {
+				return p.player
+			}
+		}
+	}
+	return "other"
+}
+
+// countListenersByPlayer returns the number of listeners per kind of player.
+func countListenersByPlayer(listeners []IcecastListener) map[string]int {
+	counts := map[string]int{}
+	for _, l := range listeners {
+		counts[classifyPlayer(l.UserAgent)]++
+	}
+	return counts
+}
+
+// countListenersByCountry returns the number of listeners per ISO country
+// code, using "unknown" for addresses not in the GeoIP database.
+func (e *Exporter) countListenersByCountry(listeners []IcecastListener) map[string]int {
+	counts := map[string]int{}
+	for _, l := range listeners {
+		country := "unknown"
+		if ip := net.ParseIP(l.IP); ip != nil {
+			if record, err := e.geoip.Country(ip); err == nil && record.Country.IsoCode != "" {
+				country = record.Country.IsoCode
+			}
+		}
+		counts[country]++
+	}
+	return counts
+}
@@ -0,0 +1,127 @@
+// Copyright 2016 Markus Lindenberg
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// openCountryDB writes a GeoLite2-Country database in the MaxMind DB format
+// that maps each of the IPv4 addresses in countries to its ISO country code,
+// and opens it.
+func openCountryDB(t *testing.T, countries map[string]string) *geoip2.Reader {
+	t.Helper()
+
+	// Records of the binary search tree are the index of a node, or -1 for
+	// no data, or -2-i for the ith country in the data section.
+	nodes := [][2]int{{-1, -1}}
+	var data bytes.Buffer
+	for address, country := range countries {
+		ip := net.ParseIP(address).To4()
+		if ip == nil {
+			t.Fatalf("%s isn't an IPv4 address", address)
+		}
+		offset := data.Len()
+		// {"country":{"iso_code":country}}
+		data.WriteString("\xe1\x47country\xe1\x48iso_code")
+		data.WriteByte(0x40 | byte(len(country)))
+		data.WriteString(country)
+
+		node := 0
+		for i := 0; i < 32; i++ {
+			bit := ip[i/8] >> (7 - uint(i%8)) & 1
+			if i == 31 {
+				nodes[node][bit] = -2 - offset
+				break
+			}
+			if nodes[node][bit] < 0 {
+				nodes = append(nodes, [2]int{-1, -1})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	var db bytes.Buffer
+	for _, node := range nodes {
+		for _, record := range node {
+			switch {
+			case record == -1:
+				record = len(nodes)
+			case record < -1:
+				record = len(nodes) + 16 + -2 - record
+			}
+			db.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	db.Write(make([]byte, 16))
+	db.Write(data.Bytes())
+	db.WriteString("\xab\xcd\xefMaxMind.com")
+	db.WriteString("\xe8")
+	db.WriteString("\x4anode_count\xc4")
+	db.Write([]byte{byte(len(nodes) >> 24), byte(len(nodes) >> 16), byte(len(nodes) >> 8), byte(len(nodes))})
+	db.WriteString("\x4brecord_size\xa1\x18")
+	db.WriteString("\x4aip_version\xa1\x04")
+	db.WriteString("\x4ddatabase_type\x50GeoLite2-Country")
+	db.WriteString("\x49languages\x00\x04")
+	db.WriteString("\x5bbinary_format_major_version\xa1\x02")
+	db.WriteString("\x5bbinary_format_minor_version\xa0")
+	db.WriteString("\x4bbuild_epoch\x08\x02\x00\x00\x00\x00\x00\x00\x00\x00")
+	db.WriteString("\x4bdescription\xe0")
+
+	filename := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	if err := ioutil.WriteFile(filename, db.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := geoip2.Open(filename)
+	if err != nil {
+		t.Fatalf("can't open the test database: %v", err)
+	}
+	t.Cleanup(func() { reader.Close() })
+	return reader
+}
+
+func TestCountListenersByCountry(t *testing.T) {
+	e := NewExporter("http://localhost:8000/status-json.xsl", Options{
+		GeoIP: openCountryDB(t, map[string]string{
+			"192.0.2.1":    "DE",
+			"192.0.2.2":    "DE",
+			"198.51.100.7": "NL",
+		}),
+	})
+
+	counts := e.countListenersByCountry([]IcecastListener{
+		{IP: "192.0.2.1"},
+		{IP: "192.0.2.1"},
+		{IP: "192.0.2.2"},
+		{IP: "198.51.100.7"},
+		// In the database's network, but not in the database.
+		{IP: "192.0.2.3"},
+		{IP: "203.0.113.1"},
+		{IP: "2001:db8::1"},
+		{IP: "not an address"},
+	})
+	want := map[string]int{"DE": 3, "NL": 1, "unknown": 4}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("got %v, want %v", counts, want)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 Markus Lindenberg
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+)
+
+// IcecastMount is a mount point as reported by /admin/listmounts.
+type IcecastMount struct {
+	Mount       string `xml:"mount,attr"`
+	Listeners   int    `xml:"listeners"`
+	Connected   int    `xml:"Connected"`
+	ContentType string `xml:"content-type"`
+}
+
+// XML structure of /admin/listmounts
+type IcecastListMounts struct {
+	Source []IcecastMount `xml:"source"`
+}
+
+// fetchMounts returns the mount points listed by /admin/listmounts. Unlike the
+// status document, this includes hidden mount points.
+func (e *Exporter) fetchMounts(ctx context.Context) ([]IcecastMount, error) {
+	body, err := e.get(ctx, e.adminURI("/admin/listmounts", nil))
+	if err != nil {
+		return nil, err
+	}
+	var l IcecastListMounts
+	if err := xml.Unmarshal(body, &l); err != nil {
+		return nil, err
+	}
+	return l.Source, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 Markus Lindenberg
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	relayListenerDelta = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "relay", "listener_delta"),
+		"The number of listeners of a mount point on this relay minus those on its master.",
+		[]string{"mount", "master"}, nil,
+	)
+	relayStreamStartDelta = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "relay", "stream_start_delta_seconds"),
+		"When the stream of a mount point started on this relay minus when it started on its master. Negative if the relay still serves a stream the master restarted since.",
+		[]string{"mount", "master"}, nil,
+	)
+)
+
+// relayCollector compares the mount points that a relay and its master both
+// serve. It uses the status of their most recent scrapes, so it should be
+// collected along with the exporters of both.
+type relayCollector struct {
+	masterName string
+	master     *Exporter
+	relay      *Exporter
+}
+
+func (c relayCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- relayListenerDelta
+	ch <- relayStreamStartDelta
+}
+
+func (c relayCollector) Collect(ch chan<- prometheus.Metric) {
+	relay, master := c.relay.LastStatus(), c.master.LastStatus()
+	if relay == nil || master == nil {
+		return
+	}
+	masterSources := map[string]IcecastStatusSource{}
+	for _, source := range master.Icestats.Source {
+		masterSources[mountOf(source.Listenurl)] = source
+	}
+	for _, source := range relay.Icestats.Source {
+		mount := mountOf(source.Listenurl)
+		masterSource, ok := masterSources[mount]
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(relayListenerDelta, prometheus.GaugeValue,
+			float64(source.Listeners-masterSource.Listeners), mount, c.masterName)
+		relayStart, masterStart := source.StreamStart.Time(), masterSource.StreamStart.Time()
+		if !relayStart.IsZero() && !masterStart.IsZero() {
+			ch <- prometheus.MustNewConstMetric(relayStreamStartDelta, prometheus.GaugeValue,
+				relayStart.Sub(masterStart).Seconds(), mount, c.masterName)
+		}
+	}
+}